@@ -11,7 +11,7 @@ import (
 
 	"github.com/pikachu0310/whisper-discord-bot/internal/config"
 	"github.com/pikachu0310/whisper-discord-bot/internal/discordbot"
-	"github.com/pikachu0310/whisper-discord-bot/internal/whisper"
+	"github.com/pikachu0310/whisper-discord-bot/internal/stt"
 )
 
 func main() {
@@ -24,8 +24,12 @@ func main() {
 		log.Fatalf("設定の読み込みに失敗: %v", err)
 	}
 
-	whisperClient := whisper.New(cfg.FWSBaseURL)
-	bot, err := discordbot.New(cfg.DiscordToken, cfg.TranscriptChannelID, whisperClient)
+	transcriber, err := stt.New(cfg.STTBackend, cfg.STTURL)
+	if err != nil {
+		log.Fatalf("文字起こしエンジンの初期化に失敗: %v", err)
+	}
+	log.Printf("speech-to-text backend=%s url=%s", cfg.STTBackend, cfg.STTURL)
+	bot, err := discordbot.New(cfg, transcriber)
 	if err != nil {
 		log.Fatalf("Bot の初期化に失敗: %v", err)
 	}
@@ -0,0 +1,137 @@
+// Command replay pushes a voice capture recorded with `!config capture on`
+// through the receiver and segmenter offline and prints the resulting
+// segments, using a stub instead of Whisper.
+//
+//	go run ./cmd/replay [flags] capture.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+	"github.com/pikachu0310/whisper-discord-bot/internal/config"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	var (
+		mode        = flag.String("segment-mode", config.DefaultSegmentMode, "utterance boundary detection: timestamp or arrival")
+		vad         = flag.String("vad", config.DefaultVADMode, "voice activity detector: off, energy or gmm")
+		filters     = flag.String("filters", config.DefaultFilters, "preprocessing filter chain")
+		jitter      = flag.Int("jitter", config.DefaultJitterDepth, "jitter buffer depth in 20ms frames")
+		layoutName  = flag.String("layout", config.DefaultLayout, "decode channel layout: mono, stereo, left or right")
+		silence     = flag.Duration("silence", time.Second, "silence that ends an utterance")
+		maxSegment  = flag.Duration("max-segment", config.DefaultMaxSegment, "maximum segment length")
+		splitWindow = flag.Duration("split-window", config.DefaultSplitWindow, "split point search window")
+		interim     = flag.Duration("interim", config.DefaultInterim, "interim snapshot interval, 0 disables")
+		grace       = flag.Duration("speaking-grace", config.DefaultSpeaking, "speaking stop grace period, 0 disables")
+		preRoll     = flag.Duration("pre-roll", config.DefaultPreRoll, "audio prepended to each utterance")
+		postRoll    = flag.Duration("post-roll", config.DefaultPostRoll, "silence appended to each final segment")
+		outDir      = flag.String("out", "", "directory to write each final segment as WAV")
+		verbose     = flag.Bool("v", false, "print receiver logs")
+	)
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] capture.jsonl")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	segmentMode, ok := audio.ParseSegmentMode(*mode)
+	if !ok {
+		log.Fatalf("不明なセグメントモードです: %s", *mode)
+	}
+	vadFactory, ok := audio.ParseVAD(*vad)
+	if !ok {
+		log.Fatalf("不明な VAD です: %s", *vad)
+	}
+	filterFactory, err := audio.ParseFilterChain(*filters)
+	if err != nil {
+		log.Fatalf("フィルタ設定が不正です: %v", err)
+	}
+	layout, ok := audio.ParseChannelLayout(*layoutName)
+	if !ok {
+		log.Fatalf("不明なチャンネル構成です: %s", *layoutName)
+	}
+	thresholds, err := config.LoadScoreThresholds()
+	if err != nil {
+		log.Fatalf("セグメント判定のしきい値が不正です: %v", err)
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			log.Fatalf("出力ディレクトリの作成に失敗: %v", err)
+		}
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("キャプチャを開けません: %v", err)
+	}
+	defer file.Close()
+	capture, err := audio.NewCaptureReader(file)
+	if err != nil {
+		log.Fatalf("キャプチャの読み込みに失敗: %v", err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	if *verbose {
+		logger = log.Default()
+	}
+
+	var segments []audio.Segment
+	err = audio.ReplayCapture(capture, audio.ReplayOptions{
+		Segmenter: audio.SegmenterOptions{
+			Silence:         *silence,
+			Mode:            segmentMode,
+			VAD:             vadFactory,
+			Filters:         filterFactory,
+			MaxSegment:      *maxSegment,
+			SplitWindow:     *splitWindow,
+			InterimInterval: *interim,
+			SpeakingGrace:   *grace,
+			PreRoll:         *preRoll,
+			PostRoll:        *postRoll,
+		},
+		Receiver: audio.ReceiverOptions{JitterDepth: *jitter, Layout: layout},
+		Logger:   logger,
+	}, func(seg audio.Segment) {
+		segments = append(segments, seg)
+	})
+	if err != nil {
+		log.Fatalf("リプレイに失敗: %v", err)
+	}
+
+	fmt.Printf("capture guild=%s channel=%s start=%s\n", capture.Start.GuildID, capture.Start.ChannelID, capture.Start.Time.Format(time.RFC3339))
+	for _, seg := range segments {
+		fmt.Println(transcribeStub(seg, thresholds))
+		if *outDir == "" || seg.Interim {
+			continue
+		}
+		name := fmt.Sprintf("%04d-%s.wav", seg.UtteranceID, seg.UserID)
+		if err := audio.WritePCM16ToWAV(filepath.Join(*outDir, name), seg.Samples, audio.SampleRate, seg.Channels); err != nil {
+			log.Fatalf("セグメントの書き出しに失敗: %v", err)
+		}
+	}
+}
+
+// transcribeStub stands in for Whisper, describing the segment and whether
+// the bot would have sent it.
+func transcribeStub(seg audio.Segment, thresholds audio.ScoreThresholds) string {
+	kind := "final"
+	if seg.Interim {
+		kind = "interim"
+	}
+	score := audio.ScoreSegment(audio.Downmix(seg.Speech(), seg.Channels), audio.SampleRate)
+	decision := "send"
+	if ok, reason := thresholds.Accept(score); !ok {
+		decision = "skip (" + reason + ")"
+	}
+	return fmt.Sprintf("utterance=%d user=%s %s %s -> %s", seg.UtteranceID, seg.UserID, kind, score, decision)
+}
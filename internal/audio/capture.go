@@ -0,0 +1,211 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CaptureVersion is the capture format version written in the start event.
+const CaptureVersion = 1
+
+// CaptureKind identifies the type of a capture event.
+type CaptureKind string
+
+const (
+	// CaptureStart is the first event of every capture.
+	CaptureStart CaptureKind = "start"
+	// CapturePacket is an Opus packet read from OpusRecv.
+	CapturePacket CaptureKind = "packet"
+	// CaptureMapping is an SSRC to user mapping learned by the voice connection.
+	CaptureMapping CaptureKind = "mapping"
+	// CaptureSpeaking is a VoiceSpeakingUpdate.
+	CaptureSpeaking CaptureKind = "speaking"
+	// CaptureDisconnect is a user leaving the voice channel.
+	CaptureDisconnect CaptureKind = "disconnect"
+)
+
+// CaptureEvent is one line of a capture file. Only the fields relevant to
+// Kind are set.
+type CaptureEvent struct {
+	Kind CaptureKind `json:"kind"`
+	// Time is when the event arrived; for packets this is the arrival time
+	// the jitter buffer saw.
+	Time time.Time `json:"time"`
+
+	Version   int    `json:"version,omitempty"`
+	GuildID   string `json:"guild_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+
+	SSRC      uint32   `json:"ssrc,omitempty"`
+	UserID    string   `json:"user_id,omitempty"`
+	Sequence  uint16   `json:"seq,omitempty"`
+	Timestamp uint32   `json:"ts,omitempty"`
+	Opus      []byte   `json:"opus,omitempty"`
+	Speaking  bool     `json:"speaking,omitempty"`
+	SSRCs     []uint32 `json:"ssrcs,omitempty"`
+}
+
+// CaptureWriter records the raw packet stream and voice events of a session
+// as JSON lines so it can be replayed offline with ReplayCapture. It is safe
+// for concurrent use.
+type CaptureWriter struct {
+	clock Clock
+
+	mu     sync.Mutex
+	buf    *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// CreateCapture creates a capture file at path.
+func CreateCapture(path, guildID, channelID string) (*CaptureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create capture: %w", err)
+	}
+	c, err := NewCaptureWriter(file, SystemClock{}, guildID, channelID)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	c.closer = file
+	return c, nil
+}
+
+// NewCaptureWriter writes the start event to w and returns a writer for the
+// rest of the session.
+func NewCaptureWriter(w io.Writer, clock Clock, guildID, channelID string) (*CaptureWriter, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	buf := bufio.NewWriter(w)
+	c := &CaptureWriter{clock: clock, buf: buf, enc: json.NewEncoder(buf)}
+	c.write(CaptureEvent{
+		Kind:      CaptureStart,
+		Time:      clock.Now(),
+		Version:   CaptureVersion,
+		GuildID:   guildID,
+		ChannelID: channelID,
+	})
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Packet records a packet read from OpusRecv at now.
+func (c *CaptureWriter) Packet(pkt *discordgo.Packet, now time.Time) {
+	c.write(CaptureEvent{
+		Kind:      CapturePacket,
+		Time:      now,
+		SSRC:      pkt.SSRC,
+		UserID:    pkt.UserID,
+		Sequence:  pkt.Sequence,
+		Timestamp: pkt.Timestamp,
+		Opus:      pkt.Opus,
+	})
+}
+
+// Mapping records an SSRC to user mapping.
+func (c *CaptureWriter) Mapping(ssrc uint32, userID string) {
+	c.write(CaptureEvent{Kind: CaptureMapping, Time: c.clock.Now(), SSRC: ssrc, UserID: userID})
+}
+
+// Speaking records a speaking update.
+func (c *CaptureWriter) Speaking(userID string, ssrc uint32, speaking bool) {
+	c.write(CaptureEvent{Kind: CaptureSpeaking, Time: c.clock.Now(), SSRC: ssrc, UserID: userID, Speaking: speaking})
+}
+
+// Disconnect records a user leaving with the SSRCs that were theirs.
+func (c *CaptureWriter) Disconnect(userID string, ssrcs []uint32) {
+	c.write(CaptureEvent{Kind: CaptureDisconnect, Time: c.clock.Now(), UserID: userID, SSRCs: ssrcs})
+}
+
+func (c *CaptureWriter) write(ev CaptureEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil || c.enc == nil {
+		return
+	}
+	if err := c.enc.Encode(ev); err != nil {
+		c.err = fmt.Errorf("write capture: %w", err)
+		return
+	}
+	// Packets are batched; other events are rare and flushed so a crash
+	// keeps the mapping history.
+	if ev.Kind != CapturePacket {
+		if err := c.buf.Flush(); err != nil {
+			c.err = fmt.Errorf("flush capture: %w", err)
+		}
+	}
+}
+
+// Err returns the first write error, if any.
+func (c *CaptureWriter) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close flushes the capture and closes the file opened by CreateCapture.
+func (c *CaptureWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enc == nil {
+		return c.err
+	}
+	c.enc = nil
+	err := c.err
+	if ferr := c.buf.Flush(); err == nil && ferr != nil {
+		err = fmt.Errorf("flush capture: %w", ferr)
+	}
+	if c.closer != nil {
+		if cerr := c.closer.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close capture: %w", cerr)
+		}
+	}
+	return err
+}
+
+// CaptureReader reads a capture written by CaptureWriter.
+type CaptureReader struct {
+	dec *json.Decoder
+	// Start is the capture's start event.
+	Start CaptureEvent
+}
+
+// NewCaptureReader reads and validates the start event.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	c := &CaptureReader{dec: json.NewDecoder(bufio.NewReader(r))}
+	if err := c.dec.Decode(&c.Start); err != nil {
+		return nil, fmt.Errorf("read capture start: %w", err)
+	}
+	if c.Start.Kind != CaptureStart {
+		return nil, fmt.Errorf("capture starts with %q event", c.Start.Kind)
+	}
+	if c.Start.Version != CaptureVersion {
+		return nil, fmt.Errorf("unsupported capture version %d", c.Start.Version)
+	}
+	return c, nil
+}
+
+// Next returns the next event, or io.EOF after the last one.
+func (c *CaptureReader) Next() (CaptureEvent, error) {
+	var ev CaptureEvent
+	if err := c.dec.Decode(&ev); err != nil {
+		if errors.Is(err, io.EOF) {
+			return CaptureEvent{}, io.EOF
+		}
+		return CaptureEvent{}, fmt.Errorf("read capture event: %w", err)
+	}
+	return ev, nil
+}
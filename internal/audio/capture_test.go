@@ -0,0 +1,145 @@
+package audio
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// writeTestCapture records a session in which alice's first packets arrive
+// before her SSRC mapping, followed by a second burst that ends with a
+// speaking stop.
+func writeTestCapture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	clock := NewManualClock(time.Unix(1000, 0))
+	c, err := NewCaptureWriter(&buf, clock, "g", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := encodeSineFrames(t, 35)
+	var seq uint16
+	var ts uint32
+	send := func(opus []byte) {
+		c.Packet(&discordgo.Packet{SSRC: 7, Sequence: seq, Timestamp: ts, Opus: opus}, clock.Now())
+		seq++
+		ts += frameSamples
+		clock.Advance(frameDuration)
+	}
+	for i, opus := range frames[:25] {
+		if i == 5 {
+			c.Mapping(7, "alice")
+		}
+		send(opus)
+	}
+	clock.Advance(2 * time.Second)
+	ts += 2 * SampleRate
+	c.Speaking("alice", 7, true)
+	for _, opus := range frames[25:] {
+		send(opus)
+	}
+	c.Speaking("alice", 7, false)
+	clock.Advance(time.Second)
+	c.Disconnect("alice", []uint32{7})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type replayedSegment struct {
+	user      string
+	utterance uint64
+	samples   int
+}
+
+func replayTestCapture(t *testing.T, data []byte) []replayedSegment {
+	t.Helper()
+	cr, err := NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu  sync.Mutex
+		got []replayedSegment
+	)
+	err = ReplayCapture(cr, ReplayOptions{
+		Segmenter: SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp, SpeakingGrace: 200 * time.Millisecond},
+		Receiver:  ReceiverOptions{JitterDepth: 3},
+		Logger:    log.New(io.Discard, "", 0),
+	}, func(seg Segment) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, replayedSegment{seg.UserID, seg.UtteranceID, len(seg.Samples)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].utterance < got[j].utterance })
+	// Utterance IDs are process-wide; compare them relative to the first.
+	for i := len(got) - 1; i >= 0; i-- {
+		got[i].utterance -= got[0].utterance
+	}
+	return got
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	data := writeTestCapture(t)
+	cr, err := NewCaptureReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Start.GuildID != "g" || cr.Start.ChannelID != "c" || !cr.Start.Time.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("unexpected start event %+v", cr.Start)
+	}
+	counts := map[CaptureKind]int{}
+	var first CaptureEvent
+	for {
+		ev, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[ev.Kind] == 0 && ev.Kind == CapturePacket {
+			first = ev
+		}
+		counts[ev.Kind]++
+	}
+	want := map[CaptureKind]int{CapturePacket: 35, CaptureMapping: 1, CaptureSpeaking: 2, CaptureDisconnect: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("unexpected event counts %v", counts)
+	}
+	if first.SSRC != 7 || first.Sequence != 0 || len(first.Opus) == 0 || !first.Time.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("unexpected first packet %+v", first)
+	}
+
+	if _, err := NewCaptureReader(bytes.NewReader([]byte(`{"kind":"packet"}`))); err == nil {
+		t.Fatalf("expected a capture without start event to be rejected")
+	}
+}
+
+func TestReplayCaptureReproducesSegmentation(t *testing.T) {
+	data := writeTestCapture(t)
+	got := replayTestCapture(t, data)
+	want := []replayedSegment{
+		// Packets received before the mapping are delivered once it arrives.
+		{"alice", 0, 25 * frameSamples},
+		// The burst after the RTP gap is a new utterance.
+		{"alice", 1, 10 * frameSamples},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected segments %+v, want %+v", got, want)
+	}
+	if again := replayTestCapture(t, data); !reflect.DeepEqual(again, got) {
+		t.Fatalf("replay is not deterministic: %+v vs %+v", again, got)
+	}
+}
@@ -0,0 +1,244 @@
+package audio
+
+import (
+	"bytes"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// maxPLCFrames caps how many consecutive frames are synthesized by Opus PLC;
+	// longer gaps are filled with silence because PLC output degrades quickly.
+	maxPLCFrames = 5
+	// maxConcealFrames is the largest sequence gap treated as packet loss. Bigger
+	// jumps are considered a stream discontinuity and are not filled.
+	maxConcealFrames = 50
+	// plcGranularity is the smallest frame size Opus can synthesize (2.5ms at 48kHz).
+	plcGranularity = 120
+)
+
+// LossStats holds packet loss counters for a single SSRC.
+type LossStats struct {
+	Lost          uint64
+	FECRecovered  uint64
+	PLCConcealed  uint64
+	SilenceFilled uint64
+	Discontinuity uint64
+	// Silence counts silence and DTX frames that were skipped, not decoded.
+	Silence uint64
+}
+
+// isSilenceFrame reports whether packet carries no audio: Discord's silence
+// frame or a DTX packet made of the TOC byte alone.
+func isSilenceFrame(packet []byte) bool {
+	return len(packet) <= 1 || bytes.Equal(packet, opusSilenceFrame)
+}
+
+// skipSilence advances the stream past a silence or DTX frame without
+// decoding it. A gap before it is not concealed; the speaker had stopped.
+func (r *Receiver) skipSilence(stream *ssrcStream, pkt *discordgo.Packet) {
+	stream.loss.Silence++
+	stream.lastSeq = pkt.Sequence
+	stream.lastTimestamp = pkt.Timestamp
+	stream.lastSamples = frameSamples
+	stream.haveLast = true
+}
+
+// concealGap returns PCM frames covering the packets missing between the last
+// decoded packet of the stream and pkt. The final missing frame is recovered
+// from pkt's in-band FEC when present, earlier ones use PLC or silence.
+func (r *Receiver) concealGap(stream *ssrcStream, pkt *discordgo.Packet) []pcmFrame {
+	if !stream.haveLast {
+		return nil
+	}
+	missing := int(uint16(pkt.Sequence - stream.lastSeq - 1))
+	if missing == 0 {
+		return nil
+	}
+	if missing > maxConcealFrames {
+		stream.loss.Discontinuity++
+		r.logger.Printf("sequence discontinuity: ssrc=%d last_seq=%d seq=%d", pkt.SSRC, stream.lastSeq, pkt.Sequence)
+		return nil
+	}
+	stream.loss.Lost += uint64(missing)
+
+	lostSamples := int(pkt.Timestamp-stream.lastTimestamp) - stream.lastSamples
+	if lostSamples <= 0 || lostSamples > 2*missing*frameSamples {
+		lostSamples = missing * frameSamples
+	}
+	lostSamples -= lostSamples % plcGranularity
+
+	var frames []pcmFrame
+	timestamp := stream.lastTimestamp + uint32(stream.lastSamples)
+	for index := 0; lostSamples > 0; index++ {
+		size := frameSamples
+		if size > lostSamples {
+			size = lostSamples
+		}
+		lostSamples -= size
+		last := lostSamples == 0
+		frames = append(frames, pcmFrame{
+			timestamp: timestamp,
+			samples:   r.concealFrame(stream, pkt, size, index, last),
+		})
+		timestamp += uint32(size)
+	}
+	r.logger.Printf("concealed loss: ssrc=%d missing=%d seq=%d", pkt.SSRC, missing, pkt.Sequence)
+	return frames
+}
+
+func (r *Receiver) concealFrame(stream *ssrcStream, next *discordgo.Packet, size, index int, last bool) []int16 {
+	if last && hasFEC(next.Opus) {
+		if pcm, err := stream.decoder.Decode(next.Opus, size, true); err == nil && len(pcm) > 0 {
+			stream.loss.FECRecovered++
+			return pcm
+		}
+	}
+	if index < maxPLCFrames {
+		if pcm, err := stream.decoder.Decode(nil, size, false); err == nil && len(pcm) > 0 {
+			stream.loss.PLCConcealed++
+			return pcm
+		}
+	}
+	stream.loss.SilenceFilled++
+	return make([]int16, size*r.opts.Layout.decodeChannels())
+}
+
+// hasFEC reports whether an Opus packet carries SILK low bitrate redundancy
+// for the previous frame, mirroring libopus' opus_packet_has_lbrr.
+func hasFEC(packet []byte) bool {
+	if len(packet) < 2 {
+		return false
+	}
+	toc := packet[0]
+	config := toc >> 3
+	if config >= 16 { // CELT-only packets never carry LBRR
+		return false
+	}
+	frame := firstFramePayload(packet)
+	if len(frame) == 0 {
+		return false
+	}
+
+	// SILK frames in an Opus frame: 10/20ms = 1, 40ms = 2, 60ms = 3.
+	silkFrames := 1
+	if config < 12 {
+		switch config & 0x3 {
+		case 2:
+			silkFrames = 2
+		case 3:
+			silkFrames = 3
+		}
+	}
+	lbrr := (frame[0]>>(7-silkFrames))&1 == 1
+	if toc&0x4 != 0 { // stereo
+		lbrr = lbrr || (frame[0]>>(6-2*silkFrames))&1 == 1
+	}
+	return lbrr
+}
+
+// firstFramePayload returns the payload of the first Opus frame in packet.
+func firstFramePayload(packet []byte) []byte {
+	data := packet[1:]
+	switch packet[0] & 0x3 {
+	case 0, 1:
+		return data
+	case 2:
+		n := skipFrameLength(data)
+		if n <= 0 {
+			return nil
+		}
+		return data[n:]
+	default:
+		if len(data) < 1 {
+			return nil
+		}
+		count := data[0]
+		data = data[1:]
+		if count&0x40 != 0 { // padding
+			for {
+				if len(data) == 0 {
+					return nil
+				}
+				b := data[0]
+				data = data[1:]
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if count&0x80 != 0 { // VBR: skip all but the last frame length
+			for i := 1; i < int(count&0x3F); i++ {
+				n := skipFrameLength(data)
+				if n <= 0 {
+					return nil
+				}
+				data = data[n:]
+			}
+		}
+		return data
+	}
+}
+
+// skipFrameLength returns the number of bytes used by an Opus frame length field.
+func skipFrameLength(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	if data[0] < 252 {
+		return 1
+	}
+	if len(data) < 2 {
+		return 0
+	}
+	return 2
+}
+
+// opusPacketSamples returns the duration of an Opus packet in 48kHz samples
+// from its TOC byte and frame count, or 0 if the packet is malformed.
+func opusPacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := int(packet[0] >> 3)
+	var frameSize int // in 48kHz samples
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60ms
+		frameSize = [...]int{480, 960, 1920, 2880}[config&0x3]
+	case config < 16: // Hybrid: 10, 20ms
+		frameSize = [...]int{480, 960}[config&0x1]
+	default: // CELT: 2.5, 5, 10, 20ms
+		frameSize = [...]int{120, 240, 480, 960}[config&0x3]
+	}
+	switch packet[0] & 0x3 {
+	case 0:
+		return frameSize
+	case 1, 2:
+		return 2 * frameSize
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		return int(packet[1]&0x3F) * frameSize
+	}
+}
+
+// decodeWithConcealment decodes pkt after filling any sequence gap before it
+// and converts the frames to the receiver's channel layout.
+func (r *Receiver) decodeWithConcealment(stream *ssrcStream, pkt *discordgo.Packet) []pcmFrame {
+	frames := r.concealGap(stream, pkt)
+	pcm := r.decodeFrame(stream.decoder, pkt.Opus)
+	stream.lastSeq = pkt.Sequence
+	stream.lastTimestamp = pkt.Timestamp
+	stream.haveLast = true
+	if len(pcm) == 0 {
+		stream.lastSamples = frameSamples
+	} else {
+		stream.lastSamples = len(pcm) / r.opts.Layout.decodeChannels()
+		frames = append(frames, pcmFrame{timestamp: pkt.Timestamp, samples: pcm})
+	}
+	for i := range frames {
+		frames[i].samples = r.opts.Layout.fromDecoded(frames[i].samples)
+	}
+	return frames
+}
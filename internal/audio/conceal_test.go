@@ -0,0 +1,84 @@
+package audio
+
+import (
+	"io"
+	"log"
+	"math"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"layeh.com/gopus"
+)
+
+func encodeSineFrames(t *testing.T, count int) [][]byte {
+	t.Helper()
+	enc, err := gopus.NewEncoder(SampleRate, 1, gopus.Voip)
+	if err != nil {
+		t.Fatalf("create encoder: %v", err)
+	}
+	frames := make([][]byte, count)
+	pcm := make([]int16, frameSamples)
+	for i := range frames {
+		for n := range pcm {
+			phase := 2 * math.Pi * 440 * float64(i*frameSamples+n) / SampleRate
+			pcm[n] = int16(8000 * math.Sin(phase))
+		}
+		frames[i], err = enc.Encode(pcm, frameSamples, 4000)
+		if err != nil {
+			t.Fatalf("encode frame: %v", err)
+		}
+	}
+	return frames
+}
+
+func TestDecodeWithConcealmentFillsGap(t *testing.T) {
+	frames := encodeSineFrames(t, 10)
+	decoder, err := gopus.NewDecoder(SampleRate, 1)
+	if err != nil {
+		t.Fatalf("create decoder: %v", err)
+	}
+	r := &Receiver{logger: log.New(io.Discard, "", 0)}
+	stream := &ssrcStream{decoder: decoder}
+
+	var total int
+	for seq, opus := range frames {
+		if seq == 4 || seq == 5 {
+			continue // simulate two lost packets
+		}
+		pkt := &discordgo.Packet{SSRC: 1, Sequence: uint16(seq), Timestamp: uint32(seq * frameSamples), Opus: opus}
+		for _, frame := range r.decodeWithConcealment(stream, pkt) {
+			if frame.timestamp != uint32(total) {
+				t.Fatalf("expected frame timestamp %d, got %d", total, frame.timestamp)
+			}
+			total += len(frame.samples)
+		}
+	}
+
+	if want := len(frames) * frameSamples; total != want {
+		t.Fatalf("expected %d samples after concealment, got %d", want, total)
+	}
+	if stream.loss.Lost != 2 {
+		t.Fatalf("expected 2 lost packets, got %d", stream.loss.Lost)
+	}
+	if got := stream.loss.FECRecovered + stream.loss.PLCConcealed + stream.loss.SilenceFilled; got != 2 {
+		t.Fatalf("expected 2 concealed frames, got %d", got)
+	}
+}
+
+func TestHasFEC(t *testing.T) {
+	cases := []struct {
+		name   string
+		packet []byte
+		want   bool
+	}{
+		{"celt only", []byte{0xF8, 0xFF, 0xFE}, false},
+		{"silk 20ms with lbrr", []byte{0x08, 0x40, 0x00}, true},
+		{"silk 20ms without lbrr", []byte{0x08, 0x80, 0x00}, false},
+		{"too short", []byte{0x08}, false},
+	}
+	for _, tc := range cases {
+		if got := hasFEC(tc.packet); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", tc.name, tc.want, got)
+		}
+	}
+}
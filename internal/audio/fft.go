@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"math"
+	"math/bits"
+)
+
+// fft performs an in-place radix-2 complex FFT. len(re) must be a power of two.
+func fft(re, im []float64) {
+	n := len(re)
+	if n <= 1 {
+		return
+	}
+	shift := 64 - uint(bits.TrailingZeros(uint(n)))
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if j > i {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := -2 * math.Pi / float64(size)
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				wr, wi := math.Cos(step*float64(k)), math.Sin(step*float64(k))
+				a, b := start+k, start+k+half
+				tr := wr*re[b] - wi*im[b]
+				ti := wr*im[b] + wi*re[b]
+				re[b], im[b] = re[a]-tr, im[a]-ti
+				re[a], im[a] = re[a]+tr, im[a]+ti
+			}
+		}
+	}
+}
+
+// nextPow2 returns the smallest power of two >= n.
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// powerSpectrum returns the Hann-windowed power spectrum of frame, zero-padded
+// to the next power of two. Bin i corresponds to i*sampleRate/(2*(len(result)-1)) Hz.
+func powerSpectrum(frame []int16) []float64 {
+	n := nextPow2(len(frame))
+	re := make([]float64, n)
+	im := make([]float64, n)
+	for i, s := range frame {
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(len(frame)))
+		re[i] = float64(s) / 32768 * w
+	}
+	fft(re, im)
+	out := make([]float64, n/2+1)
+	for i := range out {
+		out[i] = re[i]*re[i] + im[i]*im[i]
+	}
+	return out
+}
@@ -0,0 +1,275 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Filter processes one channel of a user's PCM at SampleRate in place before
+// segmentation. Implementations keep per-stream state and are not safe for
+// concurrent use.
+type Filter interface {
+	Process(samples []int16)
+}
+
+// FilterFactory creates the filter for a single user's stream.
+type FilterFactory func() Filter
+
+// FilterChain applies filters in order.
+type FilterChain []Filter
+
+// Process implements Filter.
+func (c FilterChain) Process(samples []int16) {
+	for _, f := range c {
+		f.Process(samples)
+	}
+}
+
+// Filter names and defaults accepted by ParseFilterChain.
+const (
+	FilterHighPass  = "highpass"
+	FilterNotch     = "notch"
+	FilterAGC       = "agc"
+	FilterNormalize = "normalize"
+	FilterGate      = "gate"
+
+	DefaultHighPassHz   = 80.0
+	DefaultNotchHz      = 50.0
+	DefaultAGCTargetDB  = -20.0
+	DefaultNormalizeDB  = -1.0
+	DefaultGateThreshDB = -50.0
+)
+
+// filterSpec describes one filter accepted by ParseFilterChain.
+type filterSpec struct {
+	def      float64
+	min, max float64
+	build    func(param float64) Filter
+}
+
+var filterSpecs = map[string]filterSpec{
+	FilterHighPass:  {DefaultHighPassHz, 10, 1000, func(hz float64) Filter { return NewHighPass(hz) }},
+	FilterNotch:     {DefaultNotchHz, 40, 1000, func(hz float64) Filter { return NewHumNotch(hz) }},
+	FilterAGC:       {DefaultAGCTargetDB, -40, 0, func(db float64) Filter { return NewAGC(db) }},
+	FilterNormalize: {DefaultNormalizeDB, -20, 0, func(db float64) Filter { return NewPeakNormalizer(db) }},
+	FilterGate:      {DefaultGateThreshDB, -90, 0, func(db float64) Filter { return NewNoiseGate(db) }},
+}
+
+// ParseFilterChain parses a comma separated chain such as
+// "highpass:80,notch:50,agc,normalize:-1,gate:-50". Each filter takes an
+// optional parameter: the cutoff or hum frequency in Hz for highpass and
+// notch, and a level in dBFS for agc, normalize and gate. "off" or an empty
+// spec yields a nil factory, which disables filtering.
+func ParseFilterChain(spec string) (FilterFactory, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "off" || spec == "none" {
+		return nil, nil
+	}
+	var builders []func() Filter
+	for _, item := range strings.Split(spec, ",") {
+		name, raw, hasParam := strings.Cut(strings.TrimSpace(item), ":")
+		fs, ok := filterSpecs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q", name)
+		}
+		param := fs.def
+		if hasParam {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil || math.IsNaN(v) {
+				return nil, fmt.Errorf("invalid %s parameter %q", name, raw)
+			}
+			if v < fs.min || v > fs.max {
+				return nil, fmt.Errorf("%s parameter must be between %g and %g", name, fs.min, fs.max)
+			}
+			param = v
+		}
+		build := fs.build
+		builders = append(builders, func() Filter { return build(param) })
+	}
+	return func() Filter {
+		chain := make(FilterChain, len(builders))
+		for i, build := range builders {
+			chain[i] = build()
+		}
+		return chain
+	}, nil
+}
+
+// Biquad is a second order IIR filter section.
+type Biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+const (
+	butterworthQ = 1 / math.Sqrt2
+	// humNotchQ keeps each notch about freq/10 Hz wide, so the hum is removed
+	// without touching nearby speech harmonics.
+	humNotchQ = 10
+	// humHarmonics is how many multiples of the mains frequency are notched.
+	humHarmonics = 3
+)
+
+// NewHighPass returns a Butterworth high-pass filter that removes rumble
+// below cutoff Hz.
+func NewHighPass(cutoff float64) *Biquad {
+	w0 := 2 * math.Pi * cutoff / SampleRate
+	cos, alpha := math.Cos(w0), math.Sin(w0)/(2*butterworthQ)
+	return newBiquad((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// NewNotch returns a notch filter centred on freq Hz with quality factor q.
+func NewNotch(freq, q float64) *Biquad {
+	w0 := 2 * math.Pi * freq / SampleRate
+	cos, alpha := math.Cos(w0), math.Sin(w0)/(2*q)
+	return newBiquad(1, -2*cos, 1, 1+alpha, -2*cos, 1-alpha)
+}
+
+// NewHumNotch notches mains hum at freq Hz (50 or 60) and its first harmonics.
+func NewHumNotch(freq float64) FilterChain {
+	var chain FilterChain
+	for k := 1; k <= humHarmonics && float64(k)*freq < SampleRate/2; k++ {
+		chain = append(chain, NewNotch(float64(k)*freq, humNotchQ))
+	}
+	return chain
+}
+
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *Biquad {
+	return &Biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// Process implements Filter.
+func (f *Biquad) Process(samples []int16) {
+	for i, v := range samples {
+		x := float64(v)
+		y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+		f.x2, f.x1 = f.x1, x
+		f.y2, f.y1 = f.y1, y
+		samples[i] = clampInt16(y)
+	}
+}
+
+const (
+	agcMaxGainDB = 24.0
+	agcMinGainDB = -12.0
+	// agcFloorDB keeps the level estimate from adapting to silence, which
+	// would otherwise pump background noise up to the target.
+	agcFloorDB  = -55.0
+	agcAttack   = 0.3  // per block, when the level rises
+	agcRelease  = 0.03 // per block, when the level falls
+	peakRelease = 3.0  // dB per second
+	peakMaxGain = 20.0 // dB
+	// gateHold is how many samples per channel the gate stays open after the
+	// level drops, 200ms.
+	gateHold = SampleRate / 5
+)
+
+// AGC steers the RMS level of speech towards a target so quiet and loud
+// microphones reach the segmenter at similar levels.
+type AGC struct {
+	targetDB float64
+	levelDB  float64
+	gain     float64
+}
+
+// NewAGC returns an AGC aiming for targetDB dBFS RMS.
+func NewAGC(targetDB float64) *AGC {
+	return &AGC{targetDB: targetDB, levelDB: targetDB, gain: 1}
+}
+
+// Process implements Filter.
+func (a *AGC) Process(samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	if level := frameDBFS(samples); level > agcFloorDB {
+		coef := agcRelease
+		if level > a.levelDB {
+			coef = agcAttack
+		}
+		a.levelDB += coef * (level - a.levelDB)
+	}
+	gainDB := min(max(a.targetDB-a.levelDB, agcMinGainDB), agcMaxGainDB)
+	a.gain = applyGainRamp(samples, a.gain, dbToGain(gainDB))
+}
+
+// PeakNormalizer scales audio so its recent peak sits at a target level. The
+// peak envelope follows rises instantly and decays slowly, so gain only
+// increases gradually and never pushes a new peak past the target.
+type PeakNormalizer struct {
+	target   float64
+	envelope float64
+	gain     float64
+}
+
+// NewPeakNormalizer returns a PeakNormalizer aiming for peaks at targetDB dBFS.
+func NewPeakNormalizer(targetDB float64) *PeakNormalizer {
+	return &PeakNormalizer{target: dbToGain(targetDB) * 32768, gain: 1}
+}
+
+// Process implements Filter.
+func (p *PeakNormalizer) Process(samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	var peak float64
+	for _, v := range samples {
+		peak = max(peak, math.Abs(float64(v)))
+	}
+	decay := dbToGain(-peakRelease * float64(len(samples)) / SampleRate)
+	p.envelope = max(peak, p.envelope*decay)
+	if p.envelope == 0 {
+		return
+	}
+	gain := min(p.target/p.envelope, dbToGain(peakMaxGain))
+	if gain < p.gain {
+		p.gain = gain // reduce immediately so the new peak is not clipped
+	}
+	p.gain = applyGainRamp(samples, p.gain, gain)
+}
+
+// NoiseGate mutes blocks whose level stays below a threshold, holding open
+// briefly after speech so word endings are kept. Gain changes are ramped
+// across a block to avoid clicks.
+type NoiseGate struct {
+	thresholdDB float64
+	hold        int
+	gain        float64
+}
+
+// NewNoiseGate returns a NoiseGate that closes below thresholdDB dBFS RMS.
+func NewNoiseGate(thresholdDB float64) *NoiseGate {
+	return &NoiseGate{thresholdDB: thresholdDB}
+}
+
+// Process implements Filter.
+func (g *NoiseGate) Process(samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	target := 1.0
+	if frameDBFS(samples) >= g.thresholdDB {
+		g.hold = gateHold
+	} else if g.hold > 0 {
+		g.hold -= len(samples)
+	} else {
+		target = 0
+	}
+	g.gain = applyGainRamp(samples, g.gain, target)
+}
+
+// applyGainRamp scales samples by a gain moving linearly from from to to over
+// the block and returns to.
+func applyGainRamp(samples []int16, from, to float64) float64 {
+	step := (to - from) / float64(len(samples))
+	for i, v := range samples {
+		samples[i] = clampInt16(float64(v) * (from + step*float64(i+1)))
+	}
+	return to
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
@@ -0,0 +1,170 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// runFilter feeds samples through f in 20ms blocks, as the segmenter does.
+func runFilter(f Filter, samples []int16) []int16 {
+	out := append([]int16(nil), samples...)
+	for start := 0; start < len(out); start += frameSamples {
+		f.Process(out[start:min(start+frameSamples, len(out))])
+	}
+	return out
+}
+
+func mixSignals(a, b []int16) []int16 {
+	out := make([]int16, len(a))
+	for i := range out {
+		out[i] = clampInt16(float64(a[i]) + float64(b[i]))
+	}
+	return out
+}
+
+func TestHighPassRemovesRumble(t *testing.T) {
+	n := SampleRate
+	edge := SampleRate / 10
+	rumble := runFilter(NewHighPass(DefaultHighPassHz), sineSamples(20, SampleRate, n, 8000))
+	if got := toneGainDB(rumble, 20, SampleRate, 8000, edge); got > -20 {
+		t.Fatalf("20Hz rumble attenuated by only %.1fdB", -got)
+	}
+	voice := runFilter(NewHighPass(DefaultHighPassHz), sineSamples(300, SampleRate, n, 8000))
+	if got := toneGainDB(voice, 300, SampleRate, 8000, edge); math.Abs(got) > 0.5 {
+		t.Fatalf("300Hz tone changed by %.2fdB", got)
+	}
+}
+
+func TestHumNotchRemovesMainsHarmonics(t *testing.T) {
+	n := 2 * SampleRate
+	edge := SampleRate / 2
+	for _, mains := range []float64{50, 60} {
+		hum := mixSignals(sineSamples(mains, SampleRate, n, 5000), sineSamples(2*mains, SampleRate, n, 3000))
+		signal := mixSignals(hum, sineSamples(440, SampleRate, n, 5000))
+		out := runFilter(NewHumNotch(mains), signal)
+		if got := toneGainDB(out, mains, SampleRate, 5000, edge); got > -30 {
+			t.Errorf("%gHz hum attenuated by only %.1fdB", mains, -got)
+		}
+		if got := toneGainDB(out, 2*mains, SampleRate, 3000, edge); got > -30 {
+			t.Errorf("%gHz harmonic attenuated by only %.1fdB", 2*mains, -got)
+		}
+		if got := toneGainDB(out, 440, SampleRate, 5000, edge); math.Abs(got) > 0.5 {
+			t.Errorf("440Hz tone changed by %.2fdB", got)
+		}
+	}
+}
+
+func TestAGCBringsLevelsTowardsTarget(t *testing.T) {
+	n := 3 * SampleRate
+	tail := func(samples []int16) []int16 { return samples[len(samples)-SampleRate/2:] }
+	for _, amp := range []float64{300, 3000, 25000} {
+		out := runFilter(NewAGC(DefaultAGCTargetDB), sineSamples(440, SampleRate, n, amp))
+		level := frameDBFS(tail(out))
+		inLevel := frameDBFS(tail(sineSamples(440, SampleRate, n, amp)))
+		if math.Abs(level-DefaultAGCTargetDB) > math.Abs(inLevel-DefaultAGCTargetDB) {
+			t.Errorf("amp %.0f: level %.1fdBFS moved away from target (input %.1fdBFS)", amp, level, inLevel)
+		}
+		if want := min(max(DefaultAGCTargetDB, inLevel+agcMinGainDB), inLevel+agcMaxGainDB); math.Abs(level-want) > 1 {
+			t.Errorf("amp %.0f: expected %.1fdBFS, got %.1fdBFS", amp, want, level)
+		}
+	}
+
+	// Silence must not drive the gain up.
+	agc := NewAGC(DefaultAGCTargetDB)
+	runFilter(agc, make([]int16, SampleRate))
+	if agc.gain != 1 {
+		t.Fatalf("expected unity gain after silence, got %.2f", agc.gain)
+	}
+}
+
+func TestPeakNormalizerReachesTarget(t *testing.T) {
+	target := 32768 * dbToGain(DefaultNormalizeDB)
+	out := runFilter(NewPeakNormalizer(DefaultNormalizeDB), sineSamples(440, SampleRate, 4*SampleRate, 8000))
+	var peak, tailPeak float64
+	for i, v := range out {
+		a := math.Abs(float64(v))
+		peak = max(peak, a)
+		if i >= len(out)-SampleRate/10 {
+			tailPeak = max(tailPeak, a)
+		}
+	}
+	if peak > target+1 {
+		t.Fatalf("normalized peak %.0f exceeds target %.0f", peak, target)
+	}
+	if tailPeak < target*0.95 {
+		t.Fatalf("expected peak near %.0f, got %.0f", target, tailPeak)
+	}
+
+	// A sudden loud burst is attenuated at once instead of clipping.
+	p := NewPeakNormalizer(DefaultNormalizeDB)
+	runFilter(p, sineSamples(440, SampleRate, SampleRate, 2000))
+	burst := runFilter(p, sineSamples(440, SampleRate, frameSamples, 30000))
+	for _, v := range burst {
+		if math.Abs(float64(v)) > target+1 {
+			t.Fatalf("burst sample %d exceeds target %.0f", v, target)
+		}
+	}
+}
+
+func TestNoiseGateMutesNoiseAndKeepsSpeech(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	gate := NewNoiseGate(DefaultGateThreshDB)
+	var noise []int16
+	for i := 0; i < 25; i++ {
+		noise = append(noise, noiseFrame(rng, 30)...)
+	}
+	if out := runFilter(gate, noise); frameDBFS(out) > -90 {
+		t.Fatalf("expected noise to be muted, got %.1fdBFS", frameDBFS(out))
+	}
+
+	tone := sineSamples(440, SampleRate, SampleRate/2, 5000)
+	out := runFilter(gate, tone)
+	// After the first block the gate is fully open.
+	if got := toneGainDB(out[frameSamples:], 440, SampleRate, 5000, 0); math.Abs(got) > 0.1 {
+		t.Fatalf("expected open gate to pass the tone, got %.2fdB", got)
+	}
+
+	// Noise right after speech is held open, then muted.
+	hold := runFilter(gate, noise)
+	if frameDBFS(hold[:frameSamples]) < -70 {
+		t.Fatalf("expected the gate to hold open after speech")
+	}
+	if frameDBFS(hold[len(hold)-frameSamples:]) > -90 {
+		t.Fatalf("expected the gate to close after the hold time")
+	}
+}
+
+func TestParseFilterChain(t *testing.T) {
+	for _, spec := range []string{"", "off", "OFF"} {
+		factory, err := ParseFilterChain(spec)
+		if err != nil || factory != nil {
+			t.Fatalf("ParseFilterChain(%q) = %v, %v; want nil factory", spec, factory != nil, err)
+		}
+	}
+
+	factory, err := ParseFilterChain("highpass:100, notch:60,agc,normalize:-3,gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, ok := factory().(FilterChain)
+	if !ok || len(chain) != 5 {
+		t.Fatalf("expected a chain of five filters, got %#v", factory())
+	}
+	if notch, ok := chain[1].(FilterChain); !ok || len(notch) != humHarmonics {
+		t.Fatalf("expected the hum notch at index 1, got %T", chain[1])
+	}
+	if gate, ok := chain[4].(*NoiseGate); !ok || gate.thresholdDB != DefaultGateThreshDB {
+		t.Fatalf("expected a gate with the default threshold, got %#v", chain[4])
+	}
+	// Each call yields independent per-user state.
+	if factory().(FilterChain)[0] == chain[0] {
+		t.Fatalf("expected a new filter per call")
+	}
+
+	for _, spec := range []string{"echo", "highpass:abc", "highpass:5", "gate:10", "agc:"} {
+		if _, err := ParseFilterChain(spec); err == nil {
+			t.Errorf("ParseFilterChain(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package audio
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	flacBlockSize     = 4096
+	flacBitsPerSample = 16
+	flacMaxFixedOrder = 4
+	// flacMaxPartitionOrder bounds the Rice partition search.
+	flacMaxPartitionOrder = 6
+	// flacMaxRiceParam is the largest parameter of the 4-bit Rice coding method;
+	// 15 is reserved as the escape code.
+	flacMaxRiceParam = 14
+
+	flacSubframeConstant = 0x00
+	flacSubframeVerbatim = 0x01
+	flacSubframeFixed    = 0x08
+)
+
+// EncodeFLAC encodes PCM16 samples as a FLAC stream. Each channel is coded
+// independently with the best fixed linear predictor (orders 0-4) and
+// partitioned Rice residuals, which suits speech well without LPC analysis.
+func EncodeFLAC(w io.Writer, samples []int16, sampleRate, channels int) error {
+	if channels < 1 || channels > 8 {
+		return fmt.Errorf("flac supports 1 to 8 channels, got %d", channels)
+	}
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return fmt.Errorf("flac does not support %d Hz", sampleRate)
+	}
+	frames := len(samples) / channels
+	samples = samples[:frames*channels]
+
+	if _, err := w.Write(flacStreamInfo(samples, sampleRate, channels)); err != nil {
+		return fmt.Errorf("write flac header: %w", err)
+	}
+
+	block := make([][]int32, channels)
+	for c := range block {
+		block[c] = make([]int32, flacBlockSize)
+	}
+	for frame, start := uint64(0), 0; start < frames; frame, start = frame+1, start+flacBlockSize {
+		n := min(flacBlockSize, frames-start)
+		for i := 0; i < n; i++ {
+			for c := 0; c < channels; c++ {
+				block[c][i] = int32(samples[(start+i)*channels+c])
+			}
+		}
+		for c := range block {
+			block[c] = block[c][:n]
+		}
+		if _, err := w.Write(encodeFLACFrame(frame, block)); err != nil {
+			return fmt.Errorf("write flac frame: %w", err)
+		}
+		for c := range block {
+			block[c] = block[c][:flacBlockSize]
+		}
+	}
+	return nil
+}
+
+// flacStreamInfo returns the "fLaC" marker followed by the STREAMINFO block.
+func flacStreamInfo(samples []int16, sampleRate, channels int) []byte {
+	var bw bitWriter
+	bw.writeBytes([]byte("fLaC"))
+	bw.writeBits(1, 1) // last metadata block
+	bw.writeBits(0, 7) // STREAMINFO
+	bw.writeBits(34, 24)
+	bw.writeBits(flacBlockSize, 16)
+	bw.writeBits(flacBlockSize, 16)
+	bw.writeBits(0, 24) // minimum frame size unknown
+	bw.writeBits(0, 24) // maximum frame size unknown
+	bw.writeBits(uint64(sampleRate), 20)
+	bw.writeBits(uint64(channels-1), 3)
+	bw.writeBits(flacBitsPerSample-1, 5)
+	bw.writeBits(uint64(len(samples)/channels), 36)
+
+	pcm := make([]byte, 2*len(samples))
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+	sum := md5.Sum(pcm)
+	bw.writeBytes(sum[:])
+	return bw.buf
+}
+
+func encodeFLACFrame(number uint64, block [][]int32) []byte {
+	var bw bitWriter
+	bw.writeBits(0xfff8, 16) // sync code, fixed block size strategy
+	bw.writeBits(0x7, 4)     // 16-bit block size at end of header
+	bw.writeBits(0x0, 4)     // sample rate from STREAMINFO
+	bw.writeBits(uint64(len(block)-1), 4)
+	bw.writeBits(0x4, 3) // 16 bits per sample
+	bw.writeBits(0, 1)
+	bw.writeBytes(flacUTF8(number))
+	bw.writeBits(uint64(len(block[0])-1), 16)
+	bw.writeBits(uint64(flacCRC8(bw.buf)), 8)
+
+	for _, ch := range block {
+		writeFLACSubframe(&bw, ch)
+	}
+	bw.align()
+	bw.writeBits(uint64(flacCRC16(bw.buf)), 16)
+	return bw.buf
+}
+
+func writeFLACSubframe(bw *bitWriter, samples []int32) {
+	constant := true
+	for _, v := range samples[1:] {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.writeBits(flacSubframeConstant<<1, 8)
+		bw.writeSigned(samples[0], flacBitsPerSample)
+		return
+	}
+
+	bestOrder, bestBits := -1, len(samples)*flacBitsPerSample
+	var bestResidual []int32
+	var bestPartition int
+	var bestParams []int
+	for order := 0; order <= flacMaxFixedOrder && order < len(samples); order++ {
+		residual := fixedResidual(samples, order)
+		partition, params, bits := planRice(residual, len(samples), order)
+		bits += order*flacBitsPerSample + 6
+		if bits < bestBits {
+			bestOrder, bestBits = order, bits
+			bestResidual, bestPartition, bestParams = residual, partition, params
+		}
+	}
+
+	if bestOrder < 0 {
+		bw.writeBits(flacSubframeVerbatim<<1, 8)
+		for _, v := range samples {
+			bw.writeSigned(v, flacBitsPerSample)
+		}
+		return
+	}
+
+	bw.writeBits(uint64(flacSubframeFixed+bestOrder)<<1, 8)
+	for _, v := range samples[:bestOrder] {
+		bw.writeSigned(v, flacBitsPerSample)
+	}
+	bw.writeBits(0, 2) // Rice coding with 4-bit parameters
+	bw.writeBits(uint64(bestPartition), 4)
+	offset := 0
+	for p, k := range bestParams {
+		n := len(samples) >> bestPartition
+		if p == 0 {
+			n -= bestOrder
+		}
+		bw.writeBits(uint64(k), 4)
+		for _, v := range bestResidual[offset : offset+n] {
+			bw.writeRice(zigzag(v), uint(k))
+		}
+		offset += n
+	}
+}
+
+// fixedResidual applies the FLAC fixed predictor of the given order and returns
+// the residuals for samples[order:].
+func fixedResidual(samples []int32, order int) []int32 {
+	out := make([]int32, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		var pred int32
+		switch order {
+		case 1:
+			pred = samples[i-1]
+		case 2:
+			pred = 2*samples[i-1] - samples[i-2]
+		case 3:
+			pred = 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			pred = 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+		out[i-order] = samples[i] - pred
+	}
+	return out
+}
+
+// planRice picks the partition order and per-partition Rice parameters that
+// minimise the coded size of residual, returning that size in bits.
+func planRice(residual []int32, blockSize, order int) (int, []int, int) {
+	bestPartition, bestBits := 0, -1
+	var bestParams []int
+	for p := 0; p <= flacMaxPartitionOrder; p++ {
+		if p > 0 && (blockSize%(1<<p) != 0 || blockSize>>p <= order) {
+			break
+		}
+		params := make([]int, 1<<p)
+		bits := 0
+		offset := 0
+		for i := range params {
+			n := blockSize >> p
+			if i == 0 {
+				n -= order
+			}
+			k, b := bestRiceParam(residual[offset : offset+n])
+			params[i] = k
+			bits += 4 + b
+			offset += n
+		}
+		if bestBits < 0 || bits < bestBits {
+			bestPartition, bestBits, bestParams = p, bits, params
+		}
+	}
+	return bestPartition, bestParams, bestBits
+}
+
+// bestRiceParam estimates the parameter from the mean folded residual and
+// refines it by evaluating the neighbouring parameters exactly.
+func bestRiceParam(residual []int32) (int, int) {
+	if len(residual) == 0 {
+		return 0, 0
+	}
+	var sum uint64
+	for _, v := range residual {
+		sum += uint64(zigzag(v))
+	}
+	guess := 0
+	for mean := sum / uint64(len(residual)); mean > 1 && guess < flacMaxRiceParam; mean >>= 1 {
+		guess++
+	}
+
+	bestK, bestBits := 0, -1
+	for k := max(0, guess-1); k <= min(flacMaxRiceParam, guess+1); k++ {
+		bits := len(residual) * (k + 1)
+		for _, v := range residual {
+			bits += int(zigzag(v) >> uint(k))
+		}
+		if bestBits < 0 || bits < bestBits {
+			bestK, bestBits = k, bits
+		}
+	}
+	return bestK, bestBits
+}
+
+func zigzag(v int32) uint32 {
+	return uint32(v<<1) ^ uint32(v>>31)
+}
+
+// flacUTF8 encodes a frame number with FLAC's extended UTF-8 scheme.
+func flacUTF8(v uint64) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	out := make([]byte, n)
+	for i := n - 1; i > 0; i-- {
+		out[i] = 0x80 | byte(v&0x3f)
+		v >>= 6
+	}
+	out[0] = byte(0xff<<(8-n)) | byte(v)
+	return out
+}
+
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// bitWriter accumulates an MSB-first bit stream.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits appends the low n bits of v, n <= 32.
+func (b *bitWriter) writeBits(v uint64, n uint) {
+	b.acc = b.acc<<n | v&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.nbits))
+	}
+	b.acc &= 1<<b.nbits - 1
+}
+
+func (b *bitWriter) writeSigned(v int32, n uint) {
+	b.writeBits(uint64(uint32(v)), n)
+}
+
+func (b *bitWriter) writeBytes(p []byte) {
+	for _, v := range p {
+		b.writeBits(uint64(v), 8)
+	}
+}
+
+// writeRice appends u as a unary quotient followed by k remainder bits.
+func (b *bitWriter) writeRice(u uint32, k uint) {
+	for q := u >> k; ; q -= 32 {
+		if q < 32 {
+			b.writeBits(1, uint(q)+1)
+			break
+		}
+		b.writeBits(0, 32)
+	}
+	b.writeBits(uint64(u), k)
+}
+
+// align pads the stream with zero bits to a byte boundary.
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.writeBits(0, 8-b.nbits)
+	}
+}
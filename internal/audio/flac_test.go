@@ -0,0 +1,245 @@
+package audio
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+type bitReader struct {
+	t   *testing.T
+	buf []byte
+	pos int // bit offset
+}
+
+func (r *bitReader) bits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos/8 >= len(r.buf) {
+			r.t.Fatalf("flac stream truncated")
+		}
+		bit := r.buf[r.pos/8] >> (7 - uint(r.pos%8)) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) signed(n int) int32 {
+	v := r.bits(n)
+	return int32(int64(v<<(64-n)) >> (64 - n))
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// decodeFLAC is a minimal decoder for the subset EncodeFLAC produces. It
+// verifies every checksum and returns the interleaved samples.
+func decodeFLAC(t *testing.T, data []byte) (samples []int16, sampleRate, channels int) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		t.Fatalf("missing fLaC marker")
+	}
+	r := &bitReader{t: t, buf: data, pos: 32}
+	if last, kind, length := r.bits(1), r.bits(7), r.bits(24); last != 1 || kind != 0 || length != 34 {
+		t.Fatalf("unexpected metadata header last=%d type=%d len=%d", last, kind, length)
+	}
+	r.bits(16 + 16 + 24 + 24)
+	sampleRate = int(r.bits(20))
+	channels = int(r.bits(3)) + 1
+	if bps := r.bits(5) + 1; bps != 16 {
+		t.Fatalf("unexpected bits per sample %d", bps)
+	}
+	total := int(r.bits(36))
+	wantMD5 := data[r.pos/8 : r.pos/8+16]
+	r.pos += 128
+
+	for r.pos/8 < len(data) {
+		start := r.pos / 8
+		if sync := r.bits(16); sync != 0xfff8 {
+			t.Fatalf("bad frame sync %#x", sync)
+		}
+		if code := r.bits(4); code != 0x7 {
+			t.Fatalf("unexpected block size code %d", code)
+		}
+		r.bits(4)
+		if ch := int(r.bits(4)) + 1; ch != channels {
+			t.Fatalf("frame has %d channels, want %d", ch, channels)
+		}
+		r.bits(4)
+		first := r.bits(8)
+		for mask := uint64(0x80); first&mask != 0 && mask > 1; mask >>= 1 {
+			if mask != 0x80 {
+				r.bits(8)
+			}
+		}
+		blockSize := int(r.bits(16)) + 1
+		if crc := uint8(r.bits(8)); crc != flacCRC8(data[start:r.pos/8-1]) {
+			t.Fatalf("frame header crc mismatch")
+		}
+
+		block := make([][]int32, channels)
+		for c := range block {
+			block[c] = decodeSubframe(t, r, blockSize)
+		}
+		r.align()
+		end := r.pos / 8
+		if crc := uint16(r.bits(16)); crc != flacCRC16(data[start:end]) {
+			t.Fatalf("frame crc mismatch")
+		}
+		for i := 0; i < blockSize; i++ {
+			for c := range block {
+				samples = append(samples, int16(block[c][i]))
+			}
+		}
+	}
+
+	if len(samples) != total*channels {
+		t.Fatalf("decoded %d samples, STREAMINFO says %d", len(samples), total*channels)
+	}
+	pcm := make([]byte, 2*len(samples))
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(v))
+	}
+	if sum := md5.Sum(pcm); !bytes.Equal(sum[:], wantMD5) {
+		t.Fatalf("md5 mismatch")
+	}
+	return samples, sampleRate, channels
+}
+
+func decodeSubframe(t *testing.T, r *bitReader, blockSize int) []int32 {
+	header := r.bits(8)
+	kind := int(header >> 1)
+	out := make([]int32, blockSize)
+	switch {
+	case kind == flacSubframeConstant:
+		v := r.signed(16)
+		for i := range out {
+			out[i] = v
+		}
+	case kind == flacSubframeVerbatim:
+		for i := range out {
+			out[i] = r.signed(16)
+		}
+	case kind >= flacSubframeFixed && kind <= flacSubframeFixed+flacMaxFixedOrder:
+		order := kind - flacSubframeFixed
+		for i := 0; i < order; i++ {
+			out[i] = r.signed(16)
+		}
+		if method := r.bits(2); method != 0 {
+			t.Fatalf("unexpected residual method %d", method)
+		}
+		partitionOrder := int(r.bits(4))
+		i := order
+		for p := 0; p < 1<<partitionOrder; p++ {
+			n := blockSize >> partitionOrder
+			if p == 0 {
+				n -= order
+			}
+			k := int(r.bits(4))
+			for j := 0; j < n; j++ {
+				q := 0
+				for r.bits(1) == 0 {
+					q++
+				}
+				u := uint32(q)<<k | uint32(r.bits(k))
+				residual := int32(u>>1) ^ -int32(u&1)
+				out[i] = residual + fixedPrediction(out, i, order)
+				i++
+			}
+		}
+	default:
+		t.Fatalf("unexpected subframe type %#x", kind)
+	}
+	return out
+}
+
+func fixedPrediction(s []int32, i, order int) int32 {
+	switch order {
+	case 1:
+		return s[i-1]
+	case 2:
+		return 2*s[i-1] - s[i-2]
+	case 3:
+		return 3*s[i-1] - 3*s[i-2] + s[i-3]
+	case 4:
+		return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+	}
+	return 0
+}
+
+func TestEncodeFLACRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const speechLen = 3*flacBlockSize + 123
+	var speech []int16
+	for i := 0; len(speech) < speechLen; i++ {
+		speech = append(speech, voicedFrame(i, 8000)...)
+	}
+	speech = speech[:speechLen]
+
+	noise := make([]int16, 2*5000)
+	for i := range noise {
+		noise[i] = int16(rng.Intn(65536) - 32768)
+	}
+	cases := []struct {
+		name     string
+		samples  []int16
+		channels int
+	}{
+		{"speech", speech, 1},
+		{"full-scale noise stereo", noise, 2},
+		{"silence", make([]int16, 1000), 1},
+		{"single sample", []int16{-5}, 1},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		if err := EncodeFLAC(&buf, tc.samples, 16000, tc.channels); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, rate, channels := decodeFLAC(t, buf.Bytes())
+		if rate != 16000 || channels != tc.channels {
+			t.Fatalf("%s: got %d Hz %d channels", tc.name, rate, channels)
+		}
+		if len(got) != len(tc.samples) {
+			t.Fatalf("%s: decoded %d samples, want %d", tc.name, len(got), len(tc.samples))
+		}
+		for i := range got {
+			if got[i] != tc.samples[i] {
+				t.Fatalf("%s: sample %d = %d, want %d", tc.name, i, got[i], tc.samples[i])
+			}
+		}
+	}
+}
+
+func TestEncodeFLACCompressesSpeech(t *testing.T) {
+	var samples []int16
+	for i := 0; i < 100; i++ {
+		samples = append(samples, voicedFrame(i, 6000)...)
+	}
+	var buf bytes.Buffer
+	if err := EncodeFLAC(&buf, samples, SampleRate, 1); err != nil {
+		t.Fatal(err)
+	}
+	if raw := 2 * len(samples); buf.Len() > raw/2 {
+		t.Fatalf("flac output %d bytes, expected under half of %d raw bytes", buf.Len(), raw)
+	}
+}
+
+func TestFLACUTF8(t *testing.T) {
+	cases := map[uint64][]byte{
+		0x00:    {0x00},
+		0x7f:    {0x7f},
+		0x80:    {0xc2, 0x80},
+		0x7ff:   {0xdf, 0xbf},
+		0x800:   {0xe0, 0xa0, 0x80},
+		0x10000: {0xf0, 0x90, 0x80, 0x80},
+	}
+	for v, want := range cases {
+		if got := flacUTF8(v); !bytes.Equal(got, want) {
+			t.Errorf("flacUTF8(%#x) = % x, want % x", v, got, want)
+		}
+	}
+}
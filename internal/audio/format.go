@@ -0,0 +1,60 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+)
+
+// UploadFormat is the container and codec used for segment files.
+type UploadFormat string
+
+const (
+	FormatWAV     UploadFormat = "wav"
+	FormatOggOpus UploadFormat = "ogg"
+	FormatFLAC    UploadFormat = "flac"
+)
+
+// ParseUploadFormat converts a configuration string into an UploadFormat.
+func ParseUploadFormat(s string) (UploadFormat, bool) {
+	switch s {
+	case "wav":
+		return FormatWAV, true
+	case "ogg", "opus":
+		return FormatOggOpus, true
+	case "flac":
+		return FormatFLAC, true
+	}
+	return "", false
+}
+
+// Extension returns the file extension, including the dot, servers use to detect the format.
+func (f UploadFormat) Extension() string {
+	switch f {
+	case FormatOggOpus:
+		return ".ogg"
+	case FormatFLAC:
+		return ".flac"
+	}
+	return ".wav"
+}
+
+// Supports reports whether the format can encode audio at sampleRate.
+func (f UploadFormat) Supports(sampleRate int) bool {
+	if f == FormatOggOpus {
+		return opusSampleRate(sampleRate)
+	}
+	return true
+}
+
+// EncodeSegment writes PCM16 samples to w in the given format.
+func EncodeSegment(w io.Writer, format UploadFormat, samples []int16, sampleRate, channels int) error {
+	switch format {
+	case FormatWAV:
+		return EncodeWAV(w, samples, sampleRate, channels)
+	case FormatOggOpus:
+		return EncodeOggOpus(w, samples, sampleRate, channels)
+	case FormatFLAC:
+		return EncodeFLAC(w, samples, sampleRate, channels)
+	}
+	return fmt.Errorf("unknown upload format %q", format)
+}
@@ -0,0 +1,169 @@
+package audio
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// DefaultJitterDepth is the default playout delay of the jitter buffer in 20ms frames.
+	DefaultJitterDepth = 3
+	frameDuration      = 20 * time.Millisecond
+	// maxJitterPackets bounds the number of packets held per SSRC regardless of depth.
+	maxJitterPackets = 64
+	// replayWindow is the number of released sequence numbers remembered for duplicate detection.
+	replayWindow = 64
+)
+
+// JitterStats holds counters collected by a JitterBuffer.
+type JitterStats struct {
+	Received  uint64
+	Released  uint64
+	Reordered uint64
+	Late      uint64
+	Duplicate uint64
+	Overflow  uint64
+}
+
+type jitterEntry struct {
+	pkt     *discordgo.Packet
+	arrival time.Time
+}
+
+// JitterBuffer reorders the RTP packets of a single SSRC by sequence number
+// and releases them after a fixed playout delay. It is not safe for concurrent use.
+type JitterBuffer struct {
+	delay   time.Duration
+	entries []jitterEntry // sorted by sequence number
+
+	started  bool
+	next     uint16 // next sequence number to release
+	highest  uint16 // highest sequence number received
+	haveHigh bool
+	released uint64 // bit i set when sequence next-1-i was released
+
+	stats JitterStats
+}
+
+// NewJitterBuffer returns a JitterBuffer delaying packets by depth 20ms frames.
+// A depth of zero releases packets as soon as they are popped.
+func NewJitterBuffer(depth int) *JitterBuffer {
+	if depth < 0 {
+		depth = 0
+	}
+	return &JitterBuffer{delay: time.Duration(depth) * frameDuration}
+}
+
+// Push inserts a packet received at arrival. Late and duplicate packets are dropped.
+func (j *JitterBuffer) Push(pkt *discordgo.Packet, arrival time.Time) {
+	if pkt == nil {
+		return
+	}
+	j.stats.Received++
+	seq := pkt.Sequence
+
+	if j.started && seqBefore(seq, j.next) {
+		if j.wasReleased(seq) {
+			j.stats.Duplicate++
+		} else {
+			j.stats.Late++
+		}
+		return
+	}
+
+	idx := len(j.entries)
+	for idx > 0 && seqBefore(seq, j.entries[idx-1].pkt.Sequence) {
+		idx--
+	}
+	if idx > 0 && j.entries[idx-1].pkt.Sequence == seq {
+		j.stats.Duplicate++
+		return
+	}
+
+	if j.haveHigh && seqBefore(seq, j.highest) {
+		j.stats.Reordered++
+	} else {
+		j.highest = seq
+		j.haveHigh = true
+	}
+
+	j.entries = append(j.entries, jitterEntry{})
+	copy(j.entries[idx+1:], j.entries[idx:])
+	j.entries[idx] = jitterEntry{pkt: pkt, arrival: arrival}
+}
+
+// Pop returns, in sequence order, every packet whose playout time has been reached by now.
+func (j *JitterBuffer) Pop(now time.Time) []*discordgo.Packet {
+	var out []*discordgo.Packet
+	for len(j.entries) > 0 {
+		head := j.entries[0]
+		overflow := len(j.entries) > maxJitterPackets
+		if !overflow && now.Sub(head.arrival) < j.delay {
+			break
+		}
+		if overflow {
+			j.stats.Overflow++
+		}
+		j.entries = j.entries[1:]
+		j.markReleased(head.pkt.Sequence)
+		j.stats.Released++
+		out = append(out, head.pkt)
+	}
+	if len(j.entries) == 0 {
+		j.entries = nil
+	}
+	return out
+}
+
+// Flush releases every buffered packet regardless of its playout time.
+func (j *JitterBuffer) Flush() []*discordgo.Packet {
+	out := make([]*discordgo.Packet, 0, len(j.entries))
+	for _, entry := range j.entries {
+		j.markReleased(entry.pkt.Sequence)
+		j.stats.Released++
+		out = append(out, entry.pkt)
+	}
+	j.entries = nil
+	return out
+}
+
+// Len reports the number of packets currently buffered.
+func (j *JitterBuffer) Len() int {
+	return len(j.entries)
+}
+
+// Stats returns a snapshot of the buffer's counters.
+func (j *JitterBuffer) Stats() JitterStats {
+	return j.stats
+}
+
+func (j *JitterBuffer) markReleased(seq uint16) {
+	if !j.started {
+		j.started = true
+		j.next = seq + 1
+		j.released = 1
+		return
+	}
+	shift := uint16(seq - (j.next - 1))
+	if shift >= replayWindow {
+		j.released = 0
+	} else {
+		j.released <<= shift
+	}
+	j.released |= 1
+	j.next = seq + 1
+}
+
+func (j *JitterBuffer) wasReleased(seq uint16) bool {
+	age := uint16(j.next - 1 - seq)
+	if age >= replayWindow {
+		return false
+	}
+	return j.released&(1<<age) != 0
+}
+
+// seqBefore reports whether RTP sequence a precedes b, accounting for 16-bit wraparound.
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func packet(seq uint16) *discordgo.Packet {
+	return &discordgo.Packet{SSRC: 1, Sequence: seq, Timestamp: uint32(seq) * frameSamples, Opus: []byte{0x01}}
+}
+
+func sequences(pkts []*discordgo.Packet) []uint16 {
+	out := make([]uint16, len(pkts))
+	for i, p := range pkts {
+		out[i] = p.Sequence
+	}
+	return out
+}
+
+func equalSeqs(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJitterBufferReorders(t *testing.T) {
+	jb := NewJitterBuffer(3)
+	start := time.Unix(0, 0)
+
+	jb.Push(packet(10), start)
+	jb.Push(packet(12), start.Add(5*time.Millisecond))
+	jb.Push(packet(11), start.Add(10*time.Millisecond))
+
+	if got := jb.Pop(start.Add(30 * time.Millisecond)); len(got) != 0 {
+		t.Fatalf("expected packets to be held until playout delay, got %v", sequences(got))
+	}
+
+	got := sequences(jb.Pop(start.Add(100 * time.Millisecond)))
+	if want := []uint16{10, 11, 12}; !equalSeqs(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if stats := jb.Stats(); stats.Reordered != 1 {
+		t.Fatalf("expected 1 reordered packet, got %d", stats.Reordered)
+	}
+}
+
+func TestJitterBufferDropsLateAndDuplicate(t *testing.T) {
+	jb := NewJitterBuffer(1)
+	start := time.Unix(0, 0)
+
+	jb.Push(packet(1), start)
+	jb.Push(packet(1), start)
+	jb.Push(packet(3), start)
+	jb.Pop(start.Add(time.Second))
+
+	jb.Push(packet(2), start.Add(time.Second))
+	jb.Push(packet(3), start.Add(time.Second))
+
+	if got := jb.Pop(start.Add(2 * time.Second)); len(got) != 0 {
+		t.Fatalf("expected late and duplicate packets to be dropped, got %v", sequences(got))
+	}
+	stats := jb.Stats()
+	if stats.Duplicate != 2 {
+		t.Fatalf("expected 2 duplicates, got %d", stats.Duplicate)
+	}
+	if stats.Late != 1 {
+		t.Fatalf("expected 1 late packet, got %d", stats.Late)
+	}
+}
+
+func TestJitterBufferSequenceWraparound(t *testing.T) {
+	jb := NewJitterBuffer(2)
+	start := time.Unix(0, 0)
+
+	for _, seq := range []uint16{65534, 1, 65535, 0} {
+		jb.Push(packet(seq), start)
+	}
+
+	got := sequences(jb.Pop(start.Add(time.Second)))
+	if want := []uint16{65534, 65535, 0, 1}; !equalSeqs(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	jb.Push(packet(65535), start.Add(time.Second))
+	if stats := jb.Stats(); stats.Duplicate != 1 {
+		t.Fatalf("expected duplicate across wraparound, got %+v", stats)
+	}
+}
@@ -0,0 +1,123 @@
+package audio
+
+import "fmt"
+
+// ChannelLayout selects how the stereo Opus Discord sends is decoded.
+type ChannelLayout int
+
+const (
+	// LayoutMono downmixes both channels into one.
+	LayoutMono ChannelLayout = iota
+	// LayoutStereo keeps both channels, interleaved left first.
+	LayoutStereo
+	// LayoutLeft keeps only the left channel.
+	LayoutLeft
+	// LayoutRight keeps only the right channel.
+	LayoutRight
+)
+
+// ParseChannelLayout maps "mono", "stereo", "left" or "right" to a layout.
+func ParseChannelLayout(s string) (ChannelLayout, bool) {
+	switch s {
+	case "mono":
+		return LayoutMono, true
+	case "stereo":
+		return LayoutStereo, true
+	case "left":
+		return LayoutLeft, true
+	case "right":
+		return LayoutRight, true
+	default:
+		return 0, false
+	}
+}
+
+func (l ChannelLayout) String() string {
+	switch l {
+	case LayoutMono:
+		return "mono"
+	case LayoutStereo:
+		return "stereo"
+	case LayoutLeft:
+		return "left"
+	case LayoutRight:
+		return "right"
+	default:
+		return fmt.Sprintf("ChannelLayout(%d)", int(l))
+	}
+}
+
+// Channels returns the number of interleaved channels in frames of this layout.
+func (l ChannelLayout) Channels() int {
+	if l == LayoutStereo {
+		return 2
+	}
+	return 1
+}
+
+// decodeChannels returns the channel count the Opus decoder is created
+// with. For mono, libopus downmixes stereo streams itself.
+func (l ChannelLayout) decodeChannels() int {
+	if l == LayoutMono {
+		return 1
+	}
+	return 2
+}
+
+// fromDecoded converts decoder output to the layout, reusing pcm.
+func (l ChannelLayout) fromDecoded(pcm []int16) []int16 {
+	var ch int
+	switch l {
+	case LayoutLeft:
+		ch = 0
+	case LayoutRight:
+		ch = 1
+	default:
+		return pcm
+	}
+	n := len(pcm) / 2
+	for i := range n {
+		pcm[i] = pcm[2*i+ch]
+	}
+	return pcm[:n]
+}
+
+// Downmix averages interleaved channels into mono. Mono input is returned
+// as is.
+func Downmix(samples []int16, channels int) []int16 {
+	if channels <= 1 {
+		return samples
+	}
+	out := make([]int16, len(samples)/channels)
+	for i := range out {
+		var sum int
+		for _, v := range samples[i*channels : (i+1)*channels] {
+			sum += int(v)
+		}
+		out[i] = int16(sum / channels)
+	}
+	return out
+}
+
+// channelFilters runs a separate filter per channel of interleaved samples
+// so filter state never mixes channels.
+type channelFilters []Filter
+
+// Process implements Filter.
+func (c channelFilters) Process(samples []int16) {
+	if len(c) == 1 {
+		c[0].Process(samples)
+		return
+	}
+	channels := len(c)
+	plane := make([]int16, len(samples)/channels)
+	for ch, f := range c {
+		for i := range plane {
+			plane[i] = samples[i*channels+ch]
+		}
+		f.Process(plane)
+		for i, v := range plane {
+			samples[i*channels+ch] = v
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package audio
+
+import (
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"layeh.com/gopus"
+)
+
+// encodeLeftOnlyFrames encodes stereo frames with a tone on the left channel
+// and silence on the right, as a panned Discord client would send.
+func encodeLeftOnlyFrames(t *testing.T, count int) [][]byte {
+	t.Helper()
+	enc, err := gopus.NewEncoder(SampleRate, 2, gopus.Audio)
+	if err != nil {
+		t.Fatalf("create encoder: %v", err)
+	}
+	frames := make([][]byte, count)
+	pcm := make([]int16, 2*frameSamples)
+	for i := range frames {
+		for n := 0; n < frameSamples; n++ {
+			phase := 2 * math.Pi * 440 * float64(i*frameSamples+n) / SampleRate
+			pcm[2*n] = int16(8000 * math.Sin(phase))
+		}
+		frames[i], err = enc.Encode(pcm, frameSamples, 4000)
+		if err != nil {
+			t.Fatalf("encode frame: %v", err)
+		}
+	}
+	return frames
+}
+
+func TestReceiverDecodesChannelLayouts(t *testing.T) {
+	frames := encodeLeftOnlyFrames(t, 10)
+	decode := func(layout ChannelLayout) []int16 {
+		decoder, err := gopus.NewDecoder(SampleRate, layout.decodeChannels())
+		if err != nil {
+			t.Fatalf("create decoder: %v", err)
+		}
+		r := &Receiver{logger: log.New(io.Discard, "", 0), opts: ReceiverOptions{Layout: layout}}
+		stream := &ssrcStream{decoder: decoder}
+		var pcm []int16
+		for seq, opus := range frames {
+			pkt := &discordgo.Packet{SSRC: 1, Sequence: uint16(seq), Timestamp: uint32(seq * frameSamples), Opus: opus}
+			for _, frame := range r.decodeWithConcealment(stream, pkt) {
+				pcm = append(pcm, frame.samples...)
+			}
+		}
+		// Skip the encoder's start-up.
+		return pcm[len(pcm)/2:]
+	}
+
+	stereo := decode(LayoutStereo)
+	left, right := decode(LayoutLeft), decode(LayoutRight)
+	mono := decode(LayoutMono)
+	if len(stereo) != 2*len(left) || len(left) != len(right) || len(mono) != len(left) {
+		t.Fatalf("unexpected lengths stereo=%d left=%d right=%d mono=%d", len(stereo), len(left), len(right), len(mono))
+	}
+	leftDB, rightDB, monoDB := frameDBFS(left), frameDBFS(right), frameDBFS(mono)
+	if leftDB < -20 || rightDB > leftDB-20 {
+		t.Fatalf("expected the tone on the left only, got left=%.1f right=%.1f dBFS", leftDB, rightDB)
+	}
+	// Averaging with a silent channel halves the level.
+	if monoDB < leftDB-9 || monoDB > leftDB-3 {
+		t.Fatalf("expected the downmix about 6dB below the left channel, got left=%.1f mono=%.1f dBFS", leftDB, monoDB)
+	}
+}
+
+func TestSegmenterKeepsStereoFrames(t *testing.T) {
+	var got []Segment
+	clock := NewManualClock(time.Unix(0, 0))
+	seg := NewSegmenter("guild", SegmenterOptions{
+		Silence:  time.Second,
+		Mode:     SegmentByTimestamp,
+		Clock:    clock,
+		Channels: 2,
+		VAD:      func() VoiceActivityDetector { return NewEnergyVAD() },
+		PostRoll: 100 * time.Millisecond,
+	}, func(segment Segment) {
+		got = append(got, segment)
+	})
+	frame := make([]int16, 2*frameSamples)
+	for n := 0; n < frameSamples; n++ {
+		frame[2*n] = voicedFrame(0, 4000)[n]
+	}
+
+	var ts uint32
+	for i := 0; i < 10; i++ {
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+		clock.Advance(frameDuration)
+	}
+	// The RTP gap is measured in per-channel samples.
+	ts += SampleRate * 6 / 5
+	seg.AddFrame("u", ts, frame)
+	seg.Stop()
+
+	if len(got) != 2 {
+		t.Fatalf("expected two segments, got %d", len(got))
+	}
+	tail := 2 * durationSamples(100*time.Millisecond)
+	if s := got[0]; s.Channels != 2 || s.Tail != tail || len(s.Speech()) != 10*2*frameSamples {
+		t.Fatalf("unexpected stereo segment channels=%d tail=%d speech=%d", s.Channels, s.Tail, len(s.Speech()))
+	}
+}
@@ -0,0 +1,249 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMixLatency is how long the Mixer waits for late frames before a
+	// stretch of the timeline is mixed and written.
+	DefaultMixLatency = time.Second
+	// mixMaxDrift is how far a stream's RTP position may disagree with the wall
+	// clock before the stream is re-anchored, e.g. after an SSRC is reused.
+	mixMaxDrift = time.Second
+	// mixKnee is the level, relative to full scale, above which the soft clipper
+	// starts compressing overlapping voices.
+	mixKnee = 0.7
+	// mixChunkSamples bounds how much silence is written per call.
+	mixChunkSamples = SampleRate
+	// mixBufferSize holds about a second of mono PCM16.
+	mixBufferSize = 2 * SampleRate
+)
+
+// MixerOptions configures a Mixer.
+type MixerOptions struct {
+	// Path is the output file; it is created or truncated.
+	Path string
+	// Format selects the container: FormatWAV or FormatOggOpus.
+	Format UploadFormat
+	// Channels is the number of interleaved channels in the frames passed to
+	// AddFrame and in the output. Defaults to 1.
+	Channels int
+	// Clock positions streams on the session timeline. Defaults to SystemClock.
+	Clock Clock
+	// Latency is how long the timeline is held open for late frames.
+	// Defaults to DefaultMixLatency.
+	Latency time.Duration
+}
+
+// sampleWriter is implemented by the streaming WAV and Ogg Opus writers.
+type sampleWriter interface {
+	WriteSamples(samples []int16) error
+	Close() error
+}
+
+// Mixer writes a single time-aligned mixdown of every stream in a voice
+// session. Each SSRC is placed on the session timeline by the wall clock at
+// its first frame and advanced by RTP timestamps after that. Silence fills the
+// timeline where nobody speaks and overlapping voices are soft clipped.
+type Mixer struct {
+	opts  MixerOptions
+	start time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufferedFile
+	out     sampleWriter
+	streams map[uint32]*mixStream
+	mix     []float64 // pending samples starting at written
+	written int64     // samples already written to out
+	err     error
+	closed  bool
+}
+
+type mixStream struct {
+	lastRTP  uint32
+	pos      int64 // timeline position of lastRTP
+	lastWall time.Time
+}
+
+// NewMixer creates the output file and returns a Mixer whose timeline starts now.
+func NewMixer(opts MixerOptions) (*Mixer, error) {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	if opts.Latency <= 0 {
+		opts.Latency = DefaultMixLatency
+	}
+	opts.Channels = max(opts.Channels, 1)
+	file, err := os.Create(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("create mix: %w", err)
+	}
+	m := &Mixer{
+		opts:    opts,
+		start:   opts.Clock.Now(),
+		file:    file,
+		streams: make(map[uint32]*mixStream),
+	}
+	// Buffered so that AddFrame, called with the receiver's stream lock
+	// held, rarely waits for the disk.
+	m.buf = &bufferedFile{Writer: bufio.NewWriterSize(file, mixBufferSize), file: file}
+	switch opts.Format {
+	case FormatWAV:
+		m.out, err = NewWAVWriter(m.buf, SampleRate, opts.Channels)
+	case FormatOggOpus:
+		m.out, err = NewOggOpusWriter(m.buf, SampleRate, opts.Channels)
+	default:
+		err = fmt.Errorf("unsupported mix format %q", opts.Format)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddFrame mixes a decoded frame of the SSRC starting at the given 48kHz RTP
+// timestamp. Frames arriving after their part of the timeline was written are
+// dropped.
+func (m *Mixer) AddFrame(ssrc uint32, timestamp uint32, samples []int16) {
+	if len(samples) == 0 {
+		return
+	}
+	now := m.opts.Clock.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || m.err != nil {
+		return
+	}
+
+	pos := m.positionLocked(ssrc, timestamp, now) * int64(m.opts.Channels)
+	end := pos + int64(len(samples))
+	if end <= m.written {
+		return
+	}
+	if grow := int(end-m.written) - len(m.mix); grow > 0 {
+		m.mix = append(m.mix, make([]float64, grow)...)
+	}
+	for i, v := range samples {
+		if at := pos + int64(i) - m.written; at >= 0 {
+			m.mix[at] += float64(v)
+		}
+	}
+
+	watermark := durationSamples48(now.Sub(m.start)-m.opts.Latency) * int64(m.opts.Channels)
+	if err := m.emitLocked(watermark); err != nil {
+		log.Printf("mixer: write failed: %v", err)
+		m.err = err
+	}
+}
+
+// RemoveStream forgets the timeline position of an SSRC, so audio it carries
+// later is anchored by the wall clock again.
+func (m *Mixer) RemoveStream(ssrc uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, ssrc)
+}
+
+// positionLocked returns the timeline position, in 48kHz samples per channel,
+// of the frame at timestamp.
+func (m *Mixer) positionLocked(ssrc uint32, timestamp uint32, now time.Time) int64 {
+	wallPos := durationSamples48(now.Sub(m.start))
+	stream := m.streams[ssrc]
+	if stream == nil {
+		stream = &mixStream{pos: wallPos}
+		m.streams[ssrc] = stream
+	} else {
+		pos := stream.pos + int64(int32(timestamp-stream.lastRTP))
+		expected := stream.pos + durationSamples48(now.Sub(stream.lastWall))
+		if drift := pos - expected; drift > durationSamples48(mixMaxDrift) || -drift > durationSamples48(mixMaxDrift) {
+			// The RTP timeline jumped; trust the wall clock instead.
+			pos = wallPos
+		}
+		stream.pos = pos
+	}
+	stream.lastRTP = timestamp
+	stream.lastWall = now
+	return stream.pos
+}
+
+// emitLocked soft clips and writes the timeline up to end, filling silence
+// past the buffered samples.
+func (m *Mixer) emitLocked(end int64) error {
+	for m.written < end {
+		n := int(min(end-m.written, mixChunkSamples))
+		out := make([]int16, n)
+		buffered := min(n, len(m.mix))
+		for i, v := range m.mix[:buffered] {
+			out[i] = softClip(v)
+		}
+		m.mix = m.mix[:copy(m.mix, m.mix[buffered:])]
+		if err := m.out.WriteSamples(out); err != nil {
+			return err
+		}
+		m.written += int64(n)
+	}
+	return nil
+}
+
+// Close writes the rest of the timeline, up to the later of the last frame and
+// the current time, and finalises the file.
+func (m *Mixer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	err := m.err
+	if err == nil {
+		end := max(m.written+int64(len(m.mix)), durationSamples48(m.opts.Clock.Now().Sub(m.start))*int64(m.opts.Channels))
+		err = m.emitLocked(end)
+	}
+	if err == nil {
+		err = m.out.Close()
+	}
+	if ferr := m.buf.Flush(); err == nil && ferr != nil {
+		err = fmt.Errorf("flush mix: %w", ferr)
+	}
+	if cerr := m.file.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("close mix: %w", cerr)
+	}
+	return err
+}
+
+// softClip converts a mixed sample to PCM16, passing levels below mixKnee
+// through unchanged and compressing louder ones smoothly towards full scale.
+func softClip(v float64) int16 {
+	x := v / 32768
+	if a := math.Abs(x); a > mixKnee {
+		y := mixKnee + (1-mixKnee)*math.Tanh((a-mixKnee)/(1-mixKnee))
+		x = math.Copysign(y, x)
+	}
+	return clampInt16(x * 32768)
+}
+
+// bufferedFile buffers writes to a file while still letting WAVWriter seek
+// back to patch its header.
+type bufferedFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// Seek flushes pending writes and seeks the file.
+func (b *bufferedFile) Seek(offset int64, whence int) (int64, error) {
+	if err := b.Flush(); err != nil {
+		return 0, err
+	}
+	return b.file.Seek(offset, whence)
+}
@@ -0,0 +1,142 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func constFrame(v int16) []int16 {
+	frame := make([]int16, frameSamples)
+	for i := range frame {
+		frame[i] = v
+	}
+	return frame
+}
+
+func TestMixerAlignsStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mix.wav")
+	clock := NewManualClock(time.Unix(1000, 0))
+	m, err := NewMixer(MixerOptions{Path: path, Format: FormatWAV, Clock: clock, Latency: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice starts 100ms into the session and speaks two frames 400ms apart by RTP.
+	clock.Advance(100 * time.Millisecond)
+	m.AddFrame(1, 50000, constFrame(1000))
+	// Bob joins 10ms later on an unrelated RTP timeline.
+	clock.Advance(10 * time.Millisecond)
+	m.AddFrame(2, 7, constFrame(2000))
+	// Alice's second frame arrives late; RTP places it at 500ms.
+	clock.Advance(430 * time.Millisecond)
+	m.AddFrame(1, 50000+20*frameSamples, constFrame(1000))
+	clock.Advance(time.Second)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wav, err := ReadWAVFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wav.SampleRate != SampleRate || wav.Channels != 1 {
+		t.Fatalf("unexpected format %d Hz %d ch", wav.SampleRate, wav.Channels)
+	}
+	if want := int(durationSamples48(1540 * time.Millisecond)); len(wav.Samples) != want {
+		t.Fatalf("expected %d samples up to close, got %d", want, len(wav.Samples))
+	}
+	at := func(ms int) int16 { return wav.Samples[ms*SampleRate/1000] }
+	cases := []struct {
+		ms   int
+		want int16
+	}{
+		{50, 0},     // silence before anyone speaks
+		{105, 1000}, // alice alone
+		{115, 3000}, // overlap
+		{125, 2000}, // bob alone
+		{300, 0},    // nobody speaks
+		{510, 1000}, // alice's second frame positioned by RTP
+		{1000, 0},   // trailing silence until close
+	}
+	for _, tc := range cases {
+		if got := at(tc.ms); got != tc.want {
+			t.Errorf("sample at %dms = %d, want %d", tc.ms, got, tc.want)
+		}
+	}
+}
+
+func TestMixerDropsFramesBehindWatermark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mix.wav")
+	clock := NewManualClock(time.Unix(0, 0))
+	m, err := NewMixer(MixerOptions{Path: path, Format: FormatWAV, Clock: clock, Latency: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddFrame(1, 0, constFrame(500))
+	clock.Advance(time.Second)
+	m.AddFrame(2, 0, constFrame(500))
+	// An RTP jump far from the wall clock re-anchors the stream instead of
+	// rewriting audio that was already emitted.
+	clock.Advance(20 * time.Millisecond)
+	m.AddFrame(2, 1<<31, constFrame(700))
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wav, err := ReadWAVFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wav.Samples[10]; got != 500 {
+		t.Fatalf("expected the first frame to be kept, got %d", got)
+	}
+	if got := wav.Samples[int(durationSamples48(1030*time.Millisecond))]; got != 700 {
+		t.Fatalf("expected re-anchored frame at 1020ms, got %d", got)
+	}
+}
+
+func TestSoftClip(t *testing.T) {
+	if got := softClip(10000); got != 10000 {
+		t.Fatalf("levels below the knee must pass through, got %d", got)
+	}
+	if got := softClip(-10000); got != -10000 {
+		t.Fatalf("levels below the knee must pass through, got %d", got)
+	}
+	prev := softClip(0.7 * 32768)
+	for v := 0.7 * 32768; v <= 4*32768; v += 1000 {
+		got := softClip(v)
+		if got < prev {
+			t.Fatalf("soft clip must be monotonic: %d after %d at %.0f", got, prev, v)
+		}
+		prev = got
+	}
+	if got := softClip(2 * 32767); got >= 32767 || got < 31000 {
+		t.Fatalf("two full-scale voices should approach but not hit full scale, got %d", got)
+	}
+	if got := softClip(-2 * 32767); got != -softClip(2*32767) {
+		t.Fatalf("soft clip must be symmetric, got %d", got)
+	}
+}
+
+func TestMixerWritesOggOpus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mix.ogg")
+	clock := NewManualClock(time.Unix(0, 0))
+	m, err := NewMixer(MixerOptions{Path: path, Format: FormatOggOpus, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddFrame(1, 0, constFrame(3000))
+	clock.Advance(500 * time.Millisecond)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := readOggPages(t, data)
+	if last := pages[len(pages)-1]; last.granule != opusPreSkip+durationSamples48(500*time.Millisecond) {
+		t.Fatalf("unexpected final granule %d", last.granule)
+	}
+}
@@ -0,0 +1,258 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"layeh.com/gopus"
+)
+
+const (
+	oggHeaderBOS   = 0x02
+	oggHeaderEOS   = 0x04
+	oggMaxSegments = 255
+
+	// opusPreSkip is the encoder lookahead in 48kHz samples that players drop.
+	opusPreSkip = 312
+	// opusUploadBitrate is generous for speech recognition and still ~20x smaller than PCM.
+	opusUploadBitrate = 32000
+	opusMaxPacketSize = 4000
+	oggOpusVendor     = "whisper-discord-bot"
+	// oggOpusSerial identifies the only logical stream in the file.
+	oggOpusSerial = 0x77646274
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC computes the Ogg page checksum (CRC-32, polynomial 0x04c11db7, unreflected).
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggWriter packs packets into the pages of a single logical Ogg stream.
+// Packets never span pages, which holds for anything under 64KiB.
+type oggWriter struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	lacing  []byte
+	body    []byte
+	granule int64
+	started bool
+}
+
+func newOggWriter(w io.Writer, serial uint32) *oggWriter {
+	return &oggWriter{w: w, serial: serial}
+}
+
+// writePacket queues a packet that ends at granule, flushing the current page
+// first when the packet's lacing values would not fit.
+func (o *oggWriter) writePacket(packet []byte, granule int64) error {
+	if len(o.lacing)+len(packet)/255+1 > oggMaxSegments {
+		if err := o.flush(0); err != nil {
+			return err
+		}
+	}
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			o.lacing = append(o.lacing, byte(n))
+			break
+		}
+		o.lacing = append(o.lacing, 255)
+	}
+	o.body = append(o.body, packet...)
+	o.granule = granule
+	return nil
+}
+
+// writeHeaderPacket writes packet alone on its own page, as Ogg Opus requires
+// for the identification and comment headers.
+func (o *oggWriter) writeHeaderPacket(packet []byte) error {
+	if err := o.writePacket(packet, 0); err != nil {
+		return err
+	}
+	return o.flush(0)
+}
+
+// flush writes the queued packets as one page.
+func (o *oggWriter) flush(flags byte) error {
+	if len(o.lacing) == 0 && flags&oggHeaderEOS == 0 {
+		return nil
+	}
+	if !o.started {
+		flags |= oggHeaderBOS
+		o.started = true
+	}
+	page := make([]byte, 27+len(o.lacing)+len(o.body))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(o.granule))
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.seq)
+	page[26] = byte(len(o.lacing))
+	copy(page[27:], o.lacing)
+	copy(page[27+len(o.lacing):], o.body)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	o.seq++
+	o.lacing = o.lacing[:0]
+	o.body = o.body[:0]
+	if _, err := o.w.Write(page); err != nil {
+		return fmt.Errorf("write ogg page: %w", err)
+	}
+	return nil
+}
+
+// close flushes the remaining packets on a final page whose granule position
+// marks the true end of the stream so padding in the last packet is trimmed.
+func (o *oggWriter) close(granule int64) error {
+	o.granule = granule
+	return o.flush(oggHeaderEOS)
+}
+
+// OggOpusWriter encodes a PCM16 stream of any length as Ogg Opus in 20ms packets.
+type OggOpusWriter struct {
+	encoder  *gopus.Encoder
+	ogg      *oggWriter
+	frame    int // samples per channel in a packet
+	channels int
+	scale    int64 // 48kHz granule units per input sample
+	pcm      []int16
+	fill     int   // samples buffered in pcm
+	total    int64 // samples per channel written so far
+}
+
+// NewOggOpusWriter writes the Ogg Opus headers to w. sampleRate must be one
+// Opus supports: 8000, 12000, 16000, 24000 or 48000.
+func NewOggOpusWriter(w io.Writer, sampleRate, channels int) (*OggOpusWriter, error) {
+	if channels != 1 && channels != 2 {
+		return nil, fmt.Errorf("ogg opus supports 1 or 2 channels, got %d", channels)
+	}
+	if !opusSampleRate(sampleRate) {
+		return nil, fmt.Errorf("ogg opus does not support %d Hz", sampleRate)
+	}
+	encoder, err := gopus.NewEncoder(sampleRate, channels, gopus.Voip)
+	if err != nil {
+		return nil, fmt.Errorf("create opus encoder: %w", err)
+	}
+	encoder.SetBitrate(opusUploadBitrate)
+
+	ogg := newOggWriter(w, oggOpusSerial)
+	if err := ogg.writeHeaderPacket(opusHead(sampleRate, channels)); err != nil {
+		return nil, err
+	}
+	if err := ogg.writeHeaderPacket(opusTags()); err != nil {
+		return nil, err
+	}
+	frame := sampleRate / 50
+	return &OggOpusWriter{
+		encoder:  encoder,
+		ogg:      ogg,
+		frame:    frame,
+		channels: channels,
+		scale:    int64(SampleRate / sampleRate),
+		pcm:      make([]int16, frame*channels),
+	}, nil
+}
+
+// WriteSamples appends interleaved samples, encoding every complete packet.
+func (o *OggOpusWriter) WriteSamples(samples []int16) error {
+	o.total += int64(len(samples) / o.channels)
+	for len(samples) > 0 {
+		n := copy(o.pcm[o.fill:], samples)
+		o.fill += n
+		samples = samples[n:]
+		if o.fill == len(o.pcm) {
+			if err := o.encodePacket(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *OggOpusWriter) encodePacket() error {
+	packet, err := o.encoder.Encode(o.pcm, o.frame, opusMaxPacketSize)
+	if err != nil {
+		return fmt.Errorf("encode opus frame: %w", err)
+	}
+	o.fill = 0
+	return o.ogg.writePacket(packet, o.ogg.granule+int64(o.frame)*o.scale)
+}
+
+// Close flushes the encoder lookahead with silence and ends the stream at
+// the last input sample. It does not close the underlying writer.
+func (o *OggOpusWriter) Close() error {
+	// The decoder output lags the input by opusPreSkip, so the packets must
+	// cover that much past the end for the final granule (RFC 7845 §4) to be
+	// reachable.
+	end := opusPreSkip + o.total*o.scale
+	for o.ogg.granule < end {
+		clear(o.pcm[o.fill:])
+		if err := o.encodePacket(); err != nil {
+			return err
+		}
+	}
+	return o.ogg.close(end)
+}
+
+// EncodeOggOpus encodes PCM16 samples as Ogg Opus in 20ms packets.
+func EncodeOggOpus(w io.Writer, samples []int16, sampleRate, channels int) error {
+	ow, err := NewOggOpusWriter(w, sampleRate, channels)
+	if err != nil {
+		return err
+	}
+	if err := ow.WriteSamples(samples); err != nil {
+		return err
+	}
+	return ow.Close()
+}
+
+func opusSampleRate(rate int) bool {
+	switch rate {
+	case 8000, 12000, 16000, 24000, 48000:
+		return true
+	}
+	return false
+}
+
+// opusHead builds the RFC 7845 identification header for channel mapping family 0.
+func opusHead(sampleRate, channels int) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:], opusPreSkip)
+	binary.LittleEndian.PutUint32(head[12:], uint32(sampleRate))
+	// Output gain and mapping family stay zero.
+	return head
+}
+
+// opusTags builds the RFC 7845 comment header with no user comments.
+func opusTags() []byte {
+	tags := make([]byte, 0, 16+len(oggOpusVendor))
+	tags = append(tags, "OpusTags"...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(oggOpusVendor)))
+	tags = append(tags, oggOpusVendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0)
+	return tags
+}
@@ -0,0 +1,149 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"layeh.com/gopus"
+)
+
+type oggPage struct {
+	flags   byte
+	granule int64
+	seq     uint32
+	packets [][]byte
+}
+
+// readOggPages splits an Ogg stream into pages, verifying each checksum.
+func readOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("bad ogg capture pattern")
+		}
+		nsegs := int(data[26])
+		lacing := data[27 : 27+nsegs]
+		size := 27 + nsegs
+		for _, l := range lacing {
+			size += int(l)
+		}
+		page := append([]byte(nil), data[:size]...)
+		want := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if got := oggCRC(page); got != want {
+			t.Fatalf("page %d crc %#x, want %#x", len(pages), got, want)
+		}
+
+		p := oggPage{
+			flags:   data[5],
+			granule: int64(binary.LittleEndian.Uint64(data[6:])),
+			seq:     binary.LittleEndian.Uint32(data[18:]),
+		}
+		body := data[27+nsegs : size]
+		var packet []byte
+		for _, l := range lacing {
+			packet = append(packet, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				p.packets = append(p.packets, packet)
+				packet = nil
+			}
+		}
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	return pages
+}
+
+func TestEncodeOggOpus(t *testing.T) {
+	const rate = 16000
+	const amp = 10000.0
+	samples := sineSamples(440, rate, 3*rate+77, amp)
+
+	var buf bytes.Buffer
+	if err := EncodeOggOpus(&buf, samples, rate, 1); err != nil {
+		t.Fatal(err)
+	}
+	if raw := 2 * len(samples); buf.Len() > raw/8 {
+		t.Fatalf("ogg opus output %d bytes, expected under an eighth of %d raw bytes", buf.Len(), raw)
+	}
+
+	pages := readOggPages(t, buf.Bytes())
+	if len(pages) < 3 {
+		t.Fatalf("expected header pages and audio pages, got %d pages", len(pages))
+	}
+	for i, p := range pages {
+		if p.seq != uint32(i) {
+			t.Fatalf("page %d has sequence %d", i, p.seq)
+		}
+	}
+	if pages[0].flags != oggHeaderBOS || len(pages[0].packets) != 1 || len(pages[1].packets) != 1 {
+		t.Fatalf("headers must each be alone on their own page, first with BOS")
+	}
+	head := pages[0].packets[0]
+	if string(head[:8]) != "OpusHead" || head[9] != 1 || binary.LittleEndian.Uint32(head[12:]) != rate {
+		t.Fatalf("unexpected OpusHead % x", head)
+	}
+	if string(pages[1].packets[0][:8]) != "OpusTags" {
+		t.Fatalf("second packet is not OpusTags")
+	}
+	last := pages[len(pages)-1]
+	if last.flags&oggHeaderEOS == 0 {
+		t.Fatalf("last page lacks EOS flag")
+	}
+	if want := int64(opusPreSkip + len(samples)*SampleRate/rate); last.granule != want {
+		t.Fatalf("final granule %d, want %d", last.granule, want)
+	}
+
+	decoder, err := gopus.NewDecoder(SampleRate, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []int16
+	for _, p := range pages[2:] {
+		for _, packet := range p.packets {
+			pcm, err := decoder.Decode(packet, frameSamples, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, pcm...)
+		}
+	}
+	decoded = decoded[opusPreSkip : opusPreSkip+len(samples)*SampleRate/rate]
+	if gain := toneGainDB(decoded, 440, SampleRate, amp, SampleRate/10); gain < -1 || gain > 1 {
+		t.Fatalf("decoded 440 Hz tone at %.2f dB, want within 1 dB", gain)
+	}
+}
+
+func TestEncodeOggOpusCoversFinalGranule(t *testing.T) {
+	const rate = 16000
+	frame := rate / 50
+	// Packet-aligned input and input padded by less than the encoder
+	// lookahead both need trailing silence packets.
+	for _, n := range []int{10 * frame, 10*frame + frame - 10, 10*frame + 1} {
+		var buf bytes.Buffer
+		if err := EncodeOggOpus(&buf, sineSamples(440, rate, n, 8000), rate, 1); err != nil {
+			t.Fatal(err)
+		}
+		pages := readOggPages(t, buf.Bytes())
+		packets := 0
+		for _, p := range pages[2:] {
+			packets += len(p.packets)
+		}
+		final := pages[len(pages)-1].granule
+		if want := int64(opusPreSkip + n*SampleRate/rate); final != want {
+			t.Fatalf("n=%d: final granule %d, want %d", n, final, want)
+		}
+		if decoded := int64(packets * frameSamples); decoded < final {
+			t.Fatalf("n=%d: final granule %d beyond the %d decoded samples", n, final, decoded)
+		}
+	}
+}
+
+func TestEncodeOggOpusRejectsUnsupportedRate(t *testing.T) {
+	if err := EncodeOggOpus(&bytes.Buffer{}, make([]int16, 100), 44100, 1); err == nil {
+		t.Fatalf("expected error for 44100 Hz")
+	}
+}
@@ -0,0 +1,304 @@
+package audio
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what a SegmentQueue does with a segment that
+// arrives while it is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest queued segment to make room.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowMerge appends the segment to the newest queued final segment
+	// of the same user so one upload covers both, and falls back to
+	// dropping the oldest segment when there is none.
+	OverflowMerge
+	// OverflowBlock makes Enqueue wait for room, holding back the delivery
+	// of further segments from the Segmenter; audio is still received and
+	// segmented meanwhile.
+	OverflowBlock
+)
+
+// ParseOverflowPolicy maps "drop-oldest", "merge" or "block" to a policy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, bool) {
+	switch s {
+	case "drop-oldest":
+		return OverflowDropOldest, true
+	case "merge":
+		return OverflowMerge, true
+	case "block":
+		return OverflowBlock, true
+	default:
+		return 0, false
+	}
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowMerge:
+		return "merge"
+	case OverflowBlock:
+		return "block"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// mergeGap is the silence inserted between merged segments so the
+// recogniser does not run the last word of one into the next.
+const mergeGap = 200 * time.Millisecond
+
+// QueueOptions configures a SegmentQueue.
+type QueueOptions struct {
+	// Workers is the number of segments processed concurrently. Defaults to 1.
+	Workers int
+	// Depth is how many segments may wait for a worker. Defaults to 1.
+	Depth int
+	// Policy applies once Depth segments are waiting.
+	Policy OverflowPolicy
+	// OnDrop, if set, is called with every segment the queue discards, so
+	// its provisional transcript can be withdrawn.
+	OnDrop SegmentConsumer
+}
+
+// QueueStats is a snapshot of a SegmentQueue's counters.
+type QueueStats struct {
+	// Depth is the number of segments currently waiting.
+	Depth int
+	// MaxDepth is the highest Depth seen.
+	MaxDepth int
+	// Busy is the number of workers currently processing a segment.
+	Busy      int
+	Enqueued  uint64
+	Processed uint64
+	// Dropped counts discarded segments, including interim snapshots
+	// superseded by a later segment of the same utterance.
+	Dropped uint64
+	// Merged counts segments appended to an already queued segment.
+	Merged uint64
+	// Blocked counts Enqueue calls that had to wait for room.
+	Blocked uint64
+}
+
+func (s QueueStats) String() string {
+	return fmt.Sprintf("depth=%d max=%d busy=%d enqueued=%d processed=%d dropped=%d merged=%d blocked=%d",
+		s.Depth, s.MaxDepth, s.Busy, s.Enqueued, s.Processed, s.Dropped, s.Merged, s.Blocked)
+}
+
+// SegmentQueue is a bounded work queue between the Segmenter and
+// transcription. A fixed pool of workers hands segments to the handler in
+// arrival order. It is safe for concurrent use.
+type SegmentQueue struct {
+	opts    QueueOptions
+	handler SegmentConsumer
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []Segment
+	closed   bool
+	stats    QueueStats
+	workers  sync.WaitGroup
+}
+
+// NewSegmentQueue starts the workers, which call handler for each segment.
+func NewSegmentQueue(opts QueueOptions, handler SegmentConsumer) *SegmentQueue {
+	opts.Workers = max(opts.Workers, 1)
+	opts.Depth = max(opts.Depth, 1)
+	q := &SegmentQueue{opts: opts, handler: handler}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	q.workers.Add(opts.Workers)
+	for range opts.Workers {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds seg to the queue, applying the overflow policy when it is
+// full. Queued interim snapshots of seg's utterance are superseded by it.
+// Interim snapshots never block; they are dropped when there is no room.
+// Segments enqueued after Close are dropped.
+func (q *SegmentQueue) Enqueue(seg Segment) {
+	q.mu.Lock()
+	var dropped []Segment
+	defer func() {
+		q.mu.Unlock()
+		q.reportDropped(dropped)
+	}()
+
+	if q.closed {
+		dropped = append(dropped, seg)
+		q.stats.Dropped++
+		return
+	}
+	q.stats.Enqueued++
+	dropped = q.removeLocked(func(s Segment) bool {
+		return s.Interim && s.GuildID == seg.GuildID && s.UtteranceID == seg.UtteranceID
+	}, 1)
+
+	if len(q.items) >= q.opts.Depth {
+		switch {
+		case seg.Interim:
+			// A preview is not worth stalling audio or losing queued
+			// speech for; evict an older preview or give up on this one.
+			if d := q.removeLocked(func(s Segment) bool { return s.Interim }, 1); len(d) > 0 {
+				dropped = append(dropped, d...)
+			} else {
+				dropped = append(dropped, seg)
+				q.stats.Dropped++
+				return
+			}
+		case q.opts.Policy == OverflowMerge && q.mergeLocked(seg):
+			return
+		case q.opts.Policy == OverflowBlock:
+			q.stats.Blocked++
+			for len(q.items) >= q.opts.Depth && !q.closed {
+				q.notFull.Wait()
+			}
+			if q.closed {
+				dropped = append(dropped, seg)
+				q.stats.Dropped++
+				return
+			}
+		default:
+			// Prefer losing a preview over losing speech.
+			d := q.removeLocked(func(s Segment) bool { return s.Interim }, 1)
+			if len(d) == 0 {
+				d = q.removeLocked(func(Segment) bool { return true }, 1)
+			}
+			dropped = append(dropped, d...)
+		}
+	}
+
+	q.items = append(q.items, seg)
+	q.stats.Depth = len(q.items)
+	q.stats.MaxDepth = max(q.stats.MaxDepth, q.stats.Depth)
+	q.notEmpty.Signal()
+}
+
+// mergeLocked appends seg to the newest queued final segment of the same
+// user and reports whether there was one.
+func (q *SegmentQueue) mergeLocked(seg Segment) bool {
+	for i := len(q.items) - 1; i >= 0; i-- {
+		s := &q.items[i]
+		if s.Interim || s.GuildID != seg.GuildID || s.UserID != seg.UserID || s.Channels != seg.Channels {
+			continue
+		}
+		gap := durationSamples(mergeGap) * max(s.Channels, 1)
+		samples := make([]int16, 0, len(s.Samples)+gap+len(seg.Samples))
+		samples = append(samples, s.Samples...)
+		samples = append(samples, make([]int16, gap)...)
+		s.Samples = append(samples, seg.Samples...)
+		s.Tail = seg.Tail
+		s.Merged = append(s.Merged, seg.UtteranceID)
+		s.Merged = append(s.Merged, seg.Merged...)
+		q.stats.Merged++
+		return true
+	}
+	return false
+}
+
+// removeLocked removes up to n queued segments matching match, oldest
+// first, and returns them.
+func (q *SegmentQueue) removeLocked(match func(Segment) bool, n int) []Segment {
+	var removed []Segment
+	q.items = slices.DeleteFunc(q.items, func(s Segment) bool {
+		if len(removed) < n && match(s) {
+			removed = append(removed, s)
+			return true
+		}
+		return false
+	})
+	if len(removed) > 0 {
+		q.stats.Dropped += uint64(len(removed))
+		q.stats.Depth = len(q.items)
+		q.notFull.Broadcast()
+	}
+	return removed
+}
+
+func (q *SegmentQueue) reportDropped(segments []Segment) {
+	if q.opts.OnDrop == nil {
+		return
+	}
+	for _, seg := range segments {
+		q.opts.OnDrop(seg)
+	}
+}
+
+func (q *SegmentQueue) work() {
+	defer q.workers.Done()
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		seg := q.items[0]
+		q.items = slices.Delete(q.items, 0, 1)
+		q.stats.Depth = len(q.items)
+		q.stats.Busy++
+		q.notFull.Signal()
+		q.mu.Unlock()
+
+		q.handler(seg)
+
+		q.mu.Lock()
+		q.stats.Busy--
+		q.stats.Processed++
+		q.mu.Unlock()
+	}
+}
+
+// Stats returns a snapshot of the queue's counters.
+func (q *SegmentQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// Close stops accepting segments, discards the ones still waiting and
+// returns once the workers have finished the segments they were processing.
+func (q *SegmentQueue) Close() {
+	q.close(false)
+}
+
+// Drain stops accepting segments and returns once the workers have
+// processed every segment already queued.
+func (q *SegmentQueue) Drain() {
+	q.close(true)
+}
+
+func (q *SegmentQueue) close(drain bool) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.workers.Wait()
+		return
+	}
+	q.closed = true
+	var dropped []Segment
+	if !drain {
+		dropped = q.items
+		q.items = nil
+		q.stats.Dropped += uint64(len(dropped))
+		q.stats.Depth = 0
+	}
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	q.reportDropped(dropped)
+	q.workers.Wait()
+}
@@ -0,0 +1,204 @@
+package audio
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestQueue returns a queue whose handler records segments and blocks
+// until release is closed; started waits for the handler to have been
+// entered n more times.
+func newTestQueue(t *testing.T, opts QueueOptions) (q *SegmentQueue, release chan struct{}, started func(n int), handled func() []Segment, dropped func() []Segment) {
+	t.Helper()
+	var (
+		mu         sync.Mutex
+		got, drops []Segment
+		enter      = make(chan struct{}, 64)
+	)
+	release = make(chan struct{})
+	opts.OnDrop = func(seg Segment) {
+		mu.Lock()
+		defer mu.Unlock()
+		drops = append(drops, seg)
+	}
+	q = NewSegmentQueue(opts, func(seg Segment) {
+		enter <- struct{}{}
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, seg)
+	})
+	t.Cleanup(q.Close)
+	started = func(n int) {
+		t.Helper()
+		for range n {
+			select {
+			case <-enter:
+			case <-time.After(time.Second):
+				t.Fatal("handler was not called")
+			}
+		}
+	}
+	snapshot := func(s *[]Segment) func() []Segment {
+		return func() []Segment {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]Segment(nil), *s...)
+		}
+	}
+	return q, release, started, snapshot(&got), snapshot(&drops)
+}
+
+func utteranceIDs(segments []Segment) []uint64 {
+	ids := make([]uint64, len(segments))
+	for i, seg := range segments {
+		ids[i] = seg.UtteranceID
+	}
+	return ids
+}
+
+func testSegment(user string, utterance uint64, interim bool) Segment {
+	return Segment{GuildID: "g", UserID: user, UtteranceID: utterance, Interim: interim, Samples: make([]int16, frameSamples)}
+}
+
+func TestSegmentQueueDropOldest(t *testing.T) {
+	q, release, started, handled, dropped := newTestQueue(t, QueueOptions{Workers: 1, Depth: 2, Policy: OverflowDropOldest})
+	q.Enqueue(testSegment("a", 1, false))
+	started(1)
+	q.Enqueue(testSegment("a", 2, false))
+	q.Enqueue(testSegment("b", 3, true))
+	q.Enqueue(testSegment("b", 4, false))
+	// The queued interim snapshot goes before older speech.
+	q.Enqueue(testSegment("c", 5, false))
+
+	if got, want := utteranceIDs(dropped()), []uint64{3, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dropped %v, want %v", got, want)
+	}
+	stats := q.Stats()
+	if stats.Depth != 2 || stats.MaxDepth != 2 || stats.Busy != 1 || stats.Dropped != 2 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+	close(release)
+	started(2)
+	q.Close()
+	if got, want := utteranceIDs(handled()), []uint64{1, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+}
+
+func TestSegmentQueueMerge(t *testing.T) {
+	q, release, started, handled, dropped := newTestQueue(t, QueueOptions{Workers: 1, Depth: 2, Policy: OverflowMerge})
+	q.Enqueue(testSegment("a", 1, false))
+	started(1)
+	q.Enqueue(testSegment("a", 2, false))
+	q.Enqueue(testSegment("b", 3, false))
+	q.Enqueue(testSegment("a", 4, false))
+	q.Enqueue(testSegment("a", 5, false))
+	close(release)
+	started(2)
+	q.Close()
+
+	got := handled()
+	if ids := utteranceIDs(got); !reflect.DeepEqual(ids, []uint64{1, 2, 3}) || len(dropped()) != 0 {
+		t.Fatalf("handled %v, dropped %v", ids, utteranceIDs(dropped()))
+	}
+	merged := got[1]
+	if !reflect.DeepEqual(merged.Merged, []uint64{4, 5}) {
+		t.Fatalf("unexpected merged utterances %v", merged.Merged)
+	}
+	if want := 3*frameSamples + 2*durationSamples(mergeGap); len(merged.Samples) != want {
+		t.Fatalf("merged segment has %d samples, want %d", len(merged.Samples), want)
+	}
+	if stats := q.Stats(); stats.Merged != 2 || stats.Processed != 3 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+}
+
+func TestSegmentQueueBlock(t *testing.T) {
+	q, release, started, handled, dropped := newTestQueue(t, QueueOptions{Workers: 1, Depth: 1, Policy: OverflowBlock})
+	q.Enqueue(testSegment("a", 1, false))
+	started(1)
+	q.Enqueue(testSegment("a", 2, false))
+	// Interim snapshots are dropped rather than blocking.
+	q.Enqueue(testSegment("b", 3, true))
+
+	done := make(chan struct{})
+	go func() {
+		q.Enqueue(testSegment("a", 4, false))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Enqueue returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue still blocked after the worker made room")
+	}
+	started(2)
+	q.Close()
+	if got, want := utteranceIDs(handled()), []uint64{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+	if got, want := utteranceIDs(dropped()), []uint64{3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dropped %v, want %v", got, want)
+	}
+	if stats := q.Stats(); stats.Blocked != 1 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+}
+
+func TestSegmentQueueSupersedesInterim(t *testing.T) {
+	q, release, started, handled, _ := newTestQueue(t, QueueOptions{Workers: 1, Depth: 4, Policy: OverflowBlock})
+	q.Enqueue(testSegment("a", 1, false))
+	started(1)
+	q.Enqueue(testSegment("b", 2, true))
+	q.Enqueue(testSegment("b", 2, true))
+	q.Enqueue(testSegment("b", 2, false))
+	if stats := q.Stats(); stats.Depth != 1 || stats.Dropped != 2 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+	close(release)
+	started(1)
+	q.Close()
+	if got, want := utteranceIDs(handled()), []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+}
+
+func TestSegmentQueueDrainTranscribesFlushedSpeech(t *testing.T) {
+	q, release, started, handled, dropped := newTestQueue(t, QueueOptions{Workers: 1, Depth: 4, Policy: OverflowDropOldest})
+	seg := NewSegmenter("g", SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp, Clock: NewManualClock(time.Unix(0, 0))}, q.Enqueue)
+	for _, user := range []string{"a", "b"} {
+		for i := range 10 {
+			seg.AddFrame(user, uint32(i*frameSamples), voicedFrame(i, 8000))
+		}
+	}
+
+	// Shutting down: the segmenter flushes both speakers' utterances, then
+	// the queue drains instead of discarding them.
+	seg.Stop()
+	close(release)
+	q.Drain()
+	started(2)
+
+	got := handled()
+	if len(got) != 2 || len(dropped()) != 0 {
+		t.Fatalf("handled %d segments, dropped %d; want both utterances transcribed", len(got), len(dropped()))
+	}
+	for _, s := range got {
+		if len(s.Speech()) != 10*frameSamples {
+			t.Fatalf("user %s segment has %d samples of speech, want %d", s.UserID, len(s.Speech()), 10*frameSamples)
+		}
+	}
+	// Segments arriving after the drain are dropped, not lost silently.
+	q.Enqueue(testSegment("c", 9, false))
+	if got := dropped(); len(got) != 1 || got[0].UserID != "c" {
+		t.Fatalf("expected the late segment reported as dropped, got %v", utteranceIDs(got))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
@@ -13,14 +14,14 @@ import (
 
 const (
 	// SampleRate is the PCM sample rate used by Discord voice.
-	SampleRate = 48000
-	// Channels is the number of audio channels used during transcription.
-	Channels     = 1
+	SampleRate   = 48000
 	frameSamples = 960 // 20ms at 48kHz
 	// waitForMappingTimeout defines how long to wait for SSRC mapping before dropping buffered audio.
 	waitForMappingTimeout = 5 * time.Minute
 	maxPendingDuration    = 30 * time.Second
-	maxPendingSamples     = SampleRate * Channels * int(maxPendingDuration/time.Second)
+	maxPendingSamples     = SampleRate * int(maxPendingDuration/time.Second) // per channel
+	// jitterTickInterval is how often buffered packets are checked against their playout time.
+	jitterTickInterval = 10 * time.Millisecond
 )
 
 // SSRCResolver resolves SSRC values to Discord user IDs.
@@ -29,50 +30,220 @@ type SSRCResolver interface {
 	Wait(ssrc uint32, timeout time.Duration) (string, bool)
 }
 
+// ReceiverOptions tunes how a Receiver processes incoming packets.
+type ReceiverOptions struct {
+	// JitterDepth is the per-SSRC playout delay in 20ms frames. Zero disables the delay
+	// but still drops duplicate and late packets.
+	JitterDepth int
+	// Layout selects the channels decoded frames carry. The Segmenter and
+	// Mixer must be configured with the same channel count.
+	Layout ChannelLayout
+	// Recorder, when set, receives the in-order Opus packets of every resolved
+	// user and is closed when the receiver stops.
+	Recorder PacketRecorder
+	// Mixer, when set, receives every decoded frame, including those of SSRCs
+	// still waiting for a user mapping, and is closed when the receiver stops.
+	Mixer *Mixer
+	// Capture, when set, records every packet read from OpusRecv together
+	// with SSRC mapping, speaking and disconnect events, and is closed when
+	// the receiver stops.
+	Capture *CaptureWriter
+}
+
 // Receiver consumes Discord Opus packets, decodes them to PCM, and feeds the segmenter.
 type Receiver struct {
 	segmenter *Segmenter
 	logger    *log.Logger
 	resolver  SSRCResolver
+	opts      ReceiverOptions
 
 	unknownSSRC map[uint32]struct{}
 	mu          sync.Mutex
 
 	pendingMu sync.Mutex
 	pending   map[uint32]*pendingStream
+
+	streamsMu sync.Mutex
+	streams   map[uint32]*ssrcStream
+
+	done chan struct{}
 }
 
 type pendingStream struct {
-	frames       [][]int16
+	frames       []pcmFrame
+	packets      []*discordgo.Packet // kept for the recorder until the SSRC resolves
 	totalSamples int
 	waiting      bool
 }
 
+// pcmFrame is a decoded frame tagged with the 48kHz RTP timestamp of its first sample.
+type pcmFrame struct {
+	timestamp uint32
+	samples   []int16
+}
+
+type ssrcStream struct {
+	decoder *gopus.Decoder
+	jitter  *JitterBuffer
+	loss    LossStats
+	// userID is the user the stream's audio was last attributed to.
+	userID string
+
+	haveLast      bool
+	lastSeq       uint16
+	lastTimestamp uint32
+	lastSamples   int
+}
+
 // NewReceiver creates a Receiver.
-func NewReceiver(segmenter *Segmenter, resolver SSRCResolver) *Receiver {
+func NewReceiver(segmenter *Segmenter, resolver SSRCResolver, opts ReceiverOptions) *Receiver {
+	if opts.JitterDepth < 0 {
+		opts.JitterDepth = 0
+	}
 	return &Receiver{
 		segmenter:   segmenter,
 		logger:      log.Default(),
 		resolver:    resolver,
+		opts:        opts,
 		unknownSSRC: make(map[uint32]struct{}),
 		pending:     make(map[uint32]*pendingStream),
+		streams:     make(map[uint32]*ssrcStream),
+		done:        make(chan struct{}),
 	}
 }
 
-// Start begins reading from the voice connection until ctx is done.
+// Done is closed once the receiver has stopped, after its remaining audio
+// has been handed to the segmenter and its outputs closed.
+func (r *Receiver) Done() <-chan struct{} {
+	return r.done
+}
+
+// Start begins reading from the voice connection until ctx is done. If the
+// connection never becomes ready, the recorder, mixer and capture in the
+// options are closed since no shutdown will do it.
 func (r *Receiver) Start(ctx context.Context, vc *discordgo.VoiceConnection) {
 	if vc == nil {
+		r.closeSinks()
+		close(r.done)
 		return
 	}
 
 	if err := r.waitForOpusChannel(ctx, vc); err != nil {
 		r.logger.Printf("voice receiver aborted: %v", err)
+		r.closeSinks()
+		close(r.done)
 		return
 	}
 
+	capture := r.opts.Capture
+	vc.AddSSRCMappingHandler(func(_ *discordgo.VoiceConnection, ssrc uint32, userID string) {
+		if capture != nil {
+			capture.Mapping(ssrc, userID)
+		}
+		r.RemapSSRC(ssrc, userID)
+	})
+	vc.AddDisconnectHandler(func(_ *discordgo.VoiceConnection, userID string, ssrcs []uint32) {
+		if capture != nil {
+			capture.Disconnect(userID, ssrcs)
+		}
+		r.RemoveUser(userID, ssrcs)
+	})
+	if capture != nil {
+		vc.AddHandler(func(_ *discordgo.VoiceConnection, vs *discordgo.VoiceSpeakingUpdate) {
+			if vs != nil {
+				capture.Speaking(vs.UserID, uint32(vs.SSRC), vs.Speaking)
+			}
+		})
+	}
 	go r.consume(ctx, vc)
 }
 
+// RemoveUser evicts the decoder, jitter buffer and pending audio of a departed
+// user's SSRCs and flushes their segmenter state. Packets still queued in the
+// jitter buffer are decoded first so the end of the utterance is kept.
+func (r *Receiver) RemoveUser(userID string, ssrcs []uint32) {
+	r.streamsMu.Lock()
+	for ssrc, stream := range r.streams {
+		if !slices.Contains(ssrcs, ssrc) && !r.ownedBy(ssrc, stream, userID) {
+			continue
+		}
+		for _, ready := range stream.jitter.Flush() {
+			r.processPacket(stream, ready)
+		}
+		r.logStats(ssrc, stream)
+		delete(r.streams, ssrc)
+		if !slices.Contains(ssrcs, ssrc) {
+			ssrcs = append(ssrcs, ssrc)
+		}
+	}
+	r.streamsMu.Unlock()
+
+	if r.opts.Mixer != nil {
+		for _, ssrc := range ssrcs {
+			r.opts.Mixer.RemoveStream(ssrc)
+		}
+	}
+
+	r.pendingMu.Lock()
+	for _, ssrc := range ssrcs {
+		delete(r.pending, ssrc)
+	}
+	r.pendingMu.Unlock()
+
+	r.mu.Lock()
+	for _, ssrc := range ssrcs {
+		delete(r.unknownSSRC, ssrc)
+	}
+	r.mu.Unlock()
+
+	if userID != "" {
+		r.segmenter.RemoveUser(userID)
+	}
+	r.logger.Printf("evicted voice state user=%s ssrcs=%v", userID, ssrcs)
+}
+
+// ownedBy reports whether the stream's audio belongs to userID, including
+// streams whose packets are all still waiting in the jitter buffer.
+func (r *Receiver) ownedBy(ssrc uint32, stream *ssrcStream, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	if stream.userID != "" {
+		return stream.userID == userID
+	}
+	return r.resolveImmediate(ssrc, "") == userID
+}
+
+// RemapSSRC resets the decoder and jitter state of an SSRC that Discord has
+// reassigned to a different user, so the new speaker does not inherit them.
+// Packets still queued for the previous owner are decoded as theirs first
+// and their utterance is closed.
+func (r *Receiver) RemapSSRC(ssrc uint32, userID string) {
+	r.streamsMu.Lock()
+	stream, ok := r.streams[ssrc]
+	if !ok || stream.userID == "" || stream.userID == userID {
+		r.streamsMu.Unlock()
+		return
+	}
+	previous := stream.userID
+	r.logger.Printf("ssrc remapped: ssrc=%d from=%s to=%s, resetting stream", ssrc, previous, userID)
+	for _, ready := range stream.jitter.Flush() {
+		pkt := *ready
+		pkt.UserID = previous
+		r.processPacket(stream, &pkt)
+	}
+	stream.decoder.ResetState()
+	stream.jitter = NewJitterBuffer(r.opts.JitterDepth)
+	stream.haveLast = false
+	stream.userID = userID
+	if r.opts.Mixer != nil {
+		r.opts.Mixer.RemoveStream(ssrc)
+	}
+	r.streamsMu.Unlock()
+
+	r.segmenter.RemoveUser(previous)
+}
+
 func (r *Receiver) waitForOpusChannel(ctx context.Context, vc *discordgo.VoiceConnection) error {
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
@@ -96,28 +267,11 @@ func (r *Receiver) waitForOpusChannel(ctx context.Context, vc *discordgo.VoiceCo
 }
 
 func (r *Receiver) consume(ctx context.Context, vc *discordgo.VoiceConnection) {
-	defer r.segmenter.Stop()
-
-	var (
-		mu       sync.Mutex
-		decoders = make(map[uint32]*gopus.Decoder)
-	)
-
-	getDecoder := func(ssrc uint32) (*gopus.Decoder, error) {
-		mu.Lock()
-		defer mu.Unlock()
-
-		if dec, ok := decoders[ssrc]; ok {
-			return dec, nil
-		}
+	defer close(r.done)
+	defer r.shutdown()
 
-		decoder, err := gopus.NewDecoder(SampleRate, Channels)
-		if err != nil {
-			return nil, err
-		}
-		decoders[ssrc] = decoder
-		return decoder, nil
-	}
+	ticker := time.NewTicker(jitterTickInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
@@ -127,31 +281,181 @@ func (r *Receiver) consume(ctx context.Context, vc *discordgo.VoiceConnection) {
 			if !ok {
 				return
 			}
-			r.handlePacket(pkt, getDecoder)
+			now := time.Now()
+			if r.opts.Capture != nil {
+				r.opts.Capture.Packet(pkt, now)
+			}
+			r.handlePacket(pkt, now)
+		case now := <-ticker.C:
+			r.drainStreams(now)
 		}
 	}
 }
 
-func (r *Receiver) handlePacket(pkt *discordgo.Packet, getDecoder func(uint32) (*gopus.Decoder, error)) {
+// shutdown decodes what is left in the jitter buffers, flushes the segmenter
+// and closes the recording and capture outputs.
+func (r *Receiver) shutdown() {
+	r.flushStreams()
+	r.logStreamStats()
+	r.segmenter.Stop()
+	r.closeSinks()
+}
+
+// closeSinks finalizes the recorder, mixer and capture files.
+func (r *Receiver) closeSinks() {
+	r.closeMixer()
+	r.closeRecorder()
+	if r.opts.Capture != nil {
+		if err := r.opts.Capture.Close(); err != nil {
+			r.logger.Printf("close capture failed: %v", err)
+		}
+	}
+}
+
+func (r *Receiver) handlePacket(pkt *discordgo.Packet, now time.Time) {
 	if pkt == nil || len(pkt.Opus) == 0 {
 		return
 	}
 
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	stream := r.streamLocked(pkt.SSRC)
+	if stream == nil {
+		return
+	}
+	stream.jitter.Push(pkt, now)
+	for _, ready := range stream.jitter.Pop(now) {
+		r.processPacket(stream, ready)
+	}
+}
+
+// drainStreams releases packets whose playout time has passed even when no new packets arrive.
+func (r *Receiver) drainStreams(now time.Time) {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	for _, stream := range r.streams {
+		for _, ready := range stream.jitter.Pop(now) {
+			r.processPacket(stream, ready)
+		}
+	}
+}
+
+func (r *Receiver) flushStreams() {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	for _, stream := range r.streams {
+		for _, ready := range stream.jitter.Flush() {
+			r.processPacket(stream, ready)
+		}
+	}
+}
+
+func (r *Receiver) streamLocked(ssrc uint32) *ssrcStream {
+	if stream, ok := r.streams[ssrc]; ok {
+		return stream
+	}
+	decoder, err := gopus.NewDecoder(SampleRate, r.opts.Layout.decodeChannels())
+	if err != nil {
+		r.logger.Printf("create decoder failed: %v", err)
+		return nil
+	}
+	stream := &ssrcStream{
+		decoder: decoder,
+		jitter:  NewJitterBuffer(r.opts.JitterDepth),
+	}
+	r.streams[ssrc] = stream
+	return stream
+}
+
+// LossStats returns a snapshot of the packet loss counters for every SSRC seen so far.
+func (r *Receiver) LossStats() map[uint32]LossStats {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	stats := make(map[uint32]LossStats, len(r.streams))
+	for ssrc, stream := range r.streams {
+		stats[ssrc] = stream.loss
+	}
+	return stats
+}
+
+// JitterStats returns a snapshot of the jitter buffer counters for every SSRC seen so far.
+func (r *Receiver) JitterStats() map[uint32]JitterStats {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	stats := make(map[uint32]JitterStats, len(r.streams))
+	for ssrc, stream := range r.streams {
+		stats[ssrc] = stream.jitter.Stats()
+	}
+	return stats
+}
+
+func (r *Receiver) logStreamStats() {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+
+	for ssrc, stream := range r.streams {
+		r.logStats(ssrc, stream)
+	}
+}
+
+func (r *Receiver) logStats(ssrc uint32, stream *ssrcStream) {
+	jitter, loss := stream.jitter.Stats(), stream.loss
+	r.logger.Printf("jitter stats: ssrc=%d received=%d released=%d reordered=%d late=%d duplicate=%d overflow=%d",
+		ssrc, jitter.Received, jitter.Released, jitter.Reordered, jitter.Late, jitter.Duplicate, jitter.Overflow)
+	r.logger.Printf("loss stats: ssrc=%d lost=%d fec=%d plc=%d silence=%d discontinuity=%d dtx=%d",
+		ssrc, loss.Lost, loss.FECRecovered, loss.PLCConcealed, loss.SilenceFilled, loss.Discontinuity, loss.Silence)
+}
+
+func (r *Receiver) processPacket(stream *ssrcStream, pkt *discordgo.Packet) {
 	userID := r.resolveImmediate(pkt.SSRC, pkt.UserID)
-	pcm := r.decodeFrame(pkt.SSRC, pkt.Opus, getDecoder)
-	if len(pcm) == 0 {
+	if isSilenceFrame(pkt.Opus) {
+		r.processSilence(stream, pkt, userID)
+		return
+	}
+	frames := r.decodeWithConcealment(stream, pkt)
+	if len(frames) == 0 {
 		return
 	}
+	if r.opts.Mixer != nil {
+		for _, frame := range frames {
+			r.opts.Mixer.AddFrame(pkt.SSRC, frame.timestamp, frame.samples)
+		}
+	}
 
 	if userID == "" {
 		r.logger.Printf("opcode recv: buffering frame ssrc=%d seq=%d timestamp=%d (no mapping yet)", pkt.SSRC, pkt.Sequence, pkt.Timestamp)
-		r.bufferPending(pkt.SSRC, pcm, getDecoder)
+		r.bufferPending(pkt, frames)
 		return
 	}
 
-	r.logger.Printf("opcode recv: resolved user=%s ssrc=%d seq=%d samples=%d", userID, pkt.SSRC, pkt.Sequence, len(pcm))
+	stream.userID = userID
+	if r.opts.Recorder != nil {
+		r.opts.Recorder.RecordPacket(userID, pkt)
+	}
+	for _, frame := range frames {
+		r.logger.Printf("opcode recv: resolved user=%s ssrc=%d seq=%d samples=%d", userID, pkt.SSRC, pkt.Sequence, len(frame.samples))
+		r.segmenter.AddFrame(userID, frame.timestamp, frame.samples)
+	}
+}
 
-	r.segmenter.AddSamples(userID, pcm)
+// processSilence handles a silence or DTX frame as the start of a pause in
+// the user's speech instead of decoding it into samples that would pad the
+// utterance.
+func (r *Receiver) processSilence(stream *ssrcStream, pkt *discordgo.Packet, userID string) {
+	r.skipSilence(stream, pkt)
+	if userID == "" {
+		return
+	}
+	stream.userID = userID
+	if r.opts.Recorder != nil {
+		r.opts.Recorder.RecordPacket(userID, pkt)
+	}
+	r.segmenter.EndOfSpeech(userID)
 }
 
 func (r *Receiver) resolveImmediate(ssrc uint32, initial string) string {
@@ -167,13 +471,7 @@ func (r *Receiver) resolveImmediate(ssrc uint32, initial string) string {
 	return ""
 }
 
-func (r *Receiver) decodeFrame(ssrc uint32, opusFrame []byte, getDecoder func(uint32) (*gopus.Decoder, error)) []int16 {
-	decoder, err := getDecoder(ssrc)
-	if err != nil {
-		r.logger.Printf("create decoder failed: %v", err)
-		return nil
-	}
-
+func (r *Receiver) decodeFrame(decoder *gopus.Decoder, opusFrame []byte) []int16 {
 	pcm, err := decoder.Decode(opusFrame, frameSamples, false)
 	if err != nil {
 		r.logger.Printf("decode opus failed: %v", err)
@@ -182,35 +480,44 @@ func (r *Receiver) decodeFrame(ssrc uint32, opusFrame []byte, getDecoder func(ui
 	return pcm
 }
 
-func (r *Receiver) bufferPending(ssrc uint32, pcm []int16, getDecoder func(uint32) (*gopus.Decoder, error)) {
+func (r *Receiver) bufferPending(pkt *discordgo.Packet, frames []pcmFrame) {
+	ssrc := pkt.SSRC
 	if r.resolver == nil {
 		r.logUnknownSSRC(ssrc)
 		return
 	}
 
-	startWait, totalSamples, frameCount := r.addPendingFrame(ssrc, pcm)
+	startWait, totalSamples, frameCount := r.addPending(pkt, frames)
 	r.logger.Printf("pending buffer: ssrc=%d frames=%d total_samples=%d waiting=%t", ssrc, frameCount, totalSamples, startWait)
 	if startWait {
 		go r.awaitMapping(ssrc)
 	}
 }
 
-func (r *Receiver) addPendingFrame(ssrc uint32, pcm []int16) (bool, int, int) {
+func (r *Receiver) addPending(pkt *discordgo.Packet, frames []pcmFrame) (bool, int, int) {
 	r.pendingMu.Lock()
 	defer r.pendingMu.Unlock()
 
-	stream := r.pending[ssrc]
+	stream := r.pending[pkt.SSRC]
 	if stream == nil {
 		stream = &pendingStream{}
-		r.pending[ssrc] = stream
+		r.pending[pkt.SSRC] = stream
 	}
-	stream.frames = append(stream.frames, pcm)
-	stream.totalSamples += len(pcm)
-	for stream.totalSamples > maxPendingSamples && len(stream.frames) > 0 {
-		removed := len(stream.frames[0])
+	for _, frame := range frames {
+		stream.frames = append(stream.frames, frame)
+		stream.totalSamples += len(frame.samples)
+	}
+	for stream.totalSamples > maxPendingSamples*r.opts.Layout.Channels() && len(stream.frames) > 0 {
+		removed := len(stream.frames[0].samples)
 		stream.frames = stream.frames[1:]
 		stream.totalSamples -= removed
 	}
+	if r.opts.Recorder != nil {
+		stream.packets = append(stream.packets, pkt)
+		if over := len(stream.packets) - maxPendingSamples/frameSamples; over > 0 {
+			stream.packets = stream.packets[over:]
+		}
+	}
 	if stream.waiting {
 		return false, stream.totalSamples, len(stream.frames)
 	}
@@ -230,14 +537,28 @@ func (r *Receiver) awaitMapping(ssrc uint32) {
 		r.clearPending(ssrc)
 		return
 	}
-	frames := r.drainPending(ssrc)
-	r.logger.Printf("await mapping success: ssrc=%d user=%s frames=%d", ssrc, userID, len(frames))
-	for _, frame := range frames {
-		r.segmenter.AddSamples(userID, frame)
+	r.deliverPending(ssrc, userID)
+}
+
+// deliverPending hands the audio buffered while ssrc was unmapped to the
+// recorder and the segmenter as userID's.
+func (r *Receiver) deliverPending(ssrc uint32, userID string) {
+	pending := r.drainPending(ssrc)
+	if pending == nil {
+		return
+	}
+	r.logger.Printf("await mapping success: ssrc=%d user=%s frames=%d", ssrc, userID, len(pending.frames))
+	if r.opts.Recorder != nil {
+		for _, pkt := range pending.packets {
+			r.opts.Recorder.RecordPacket(userID, pkt)
+		}
+	}
+	for _, frame := range pending.frames {
+		r.segmenter.AddFrame(userID, frame.timestamp, frame.samples)
 	}
 }
 
-func (r *Receiver) drainPending(ssrc uint32) [][]int16 {
+func (r *Receiver) drainPending(ssrc uint32) *pendingStream {
 	r.pendingMu.Lock()
 	defer r.pendingMu.Unlock()
 	stream := r.pending[ssrc]
@@ -245,7 +566,25 @@ func (r *Receiver) drainPending(ssrc uint32) [][]int16 {
 		return nil
 	}
 	delete(r.pending, ssrc)
-	return stream.frames
+	return stream
+}
+
+func (r *Receiver) closeRecorder() {
+	if r.opts.Recorder == nil {
+		return
+	}
+	if err := r.opts.Recorder.Close(); err != nil {
+		r.logger.Printf("close recorder failed: %v", err)
+	}
+}
+
+func (r *Receiver) closeMixer() {
+	if r.opts.Mixer == nil {
+		return
+	}
+	if err := r.opts.Mixer.Close(); err != nil {
+		r.logger.Printf("close mixer failed: %v", err)
+	}
 }
 
 func (r *Receiver) clearPending(ssrc uint32) {
@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type staticResolver map[uint32]string
+
+func (s staticResolver) Resolve(ssrc uint32) (string, bool) {
+	userID, ok := s[ssrc]
+	return userID, ok
+}
+
+func (s staticResolver) Wait(ssrc uint32, _ time.Duration) (string, bool) {
+	return s.Resolve(ssrc)
+}
+
+func newTestReceiver(t *testing.T, resolver SSRCResolver, depth int) (*Receiver, chan capturedSegment) {
+	t.Helper()
+	seg, _, out := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp})
+	r := NewReceiver(seg, resolver, ReceiverOptions{JitterDepth: depth})
+	r.logger = log.New(io.Discard, "", 0)
+	return r, out
+}
+
+func TestReceiverRemoveUserEvictsState(t *testing.T) {
+	r, out := newTestReceiver(t, staticResolver{7: "alice"}, 3)
+	frames := encodeSineFrames(t, 5)
+	start := time.Unix(0, 0)
+	for seq, opus := range frames {
+		r.handlePacket(&discordgo.Packet{SSRC: 7, Sequence: uint16(seq), Timestamp: uint32(seq * frameSamples), Opus: opus}, start)
+	}
+	expectNoSegment(t, out)
+
+	r.RemoveUser("alice", nil)
+	// Packets still held by the jitter buffer are decoded before eviction.
+	expectSegment(t, out, len(frames)*frameSamples)
+	if len(r.LossStats()) != 0 {
+		t.Fatalf("expected stream state to be evicted, got %v", r.LossStats())
+	}
+	if len(r.segmenter.buffers) != 0 {
+		t.Fatalf("expected segmenter state to be evicted")
+	}
+}
+
+func TestReceiverRemapResetsStream(t *testing.T) {
+	resolver := staticResolver{7: "alice"}
+	r, out := newTestReceiver(t, resolver, 3)
+	frames := encodeSineFrames(t, 4)
+	now := time.Unix(0, 0)
+	r.handlePacket(&discordgo.Packet{SSRC: 7, Sequence: 0, Timestamp: 0, Opus: frames[0]}, now)
+	now = now.Add(3 * frameDuration)
+	r.drainStreams(now)
+	// These are still in the jitter buffer when the SSRC changes hands.
+	for seq, opus := range frames[1:3] {
+		r.handlePacket(&discordgo.Packet{SSRC: 7, Sequence: uint16(seq + 1), Timestamp: uint32((seq + 1) * frameSamples), Opus: opus}, now)
+	}
+
+	resolver[7] = "bob"
+	r.RemapSSRC(7, "bob")
+	if got := expectSegment(t, out, 3*frameSamples); got.userID != "alice" {
+		t.Fatalf("expected alice's queued audio, got a segment for %s", got.userID)
+	}
+	stream := r.streams[7]
+	if stream.haveLast || stream.userID != "bob" {
+		t.Fatalf("expected reset stream owned by bob, got haveLast=%t user=%s", stream.haveLast, stream.userID)
+	}
+
+	// A sequence jump after the remap must not be concealed as loss of the old speaker's stream.
+	r.handlePacket(&discordgo.Packet{SSRC: 7, Sequence: 500, Timestamp: 500 * frameSamples, Opus: frames[3]}, now)
+	r.drainStreams(now.Add(time.Second))
+	if lost := r.LossStats()[7].Lost; lost != 0 {
+		t.Fatalf("expected no concealment across remap, got %d lost", lost)
+	}
+}
+
+func TestReceiverTreatsSilenceFramesAsEndOfSpeech(t *testing.T) {
+	r, out := newTestReceiver(t, staticResolver{7: "alice"}, 0)
+	clock := r.segmenter.opts.Clock.(*ManualClock)
+	frames := encodeSineFrames(t, 8)
+	var seq uint16
+	send := func(opus []byte) {
+		r.handlePacket(&discordgo.Packet{SSRC: 7, Sequence: seq, Timestamp: uint32(seq) * frameSamples, Opus: opus}, clock.Now())
+		seq++
+	}
+	for _, opus := range frames[:5] {
+		send(opus)
+	}
+	expectNoSegment(t, out)
+
+	// Silence frames start the silence timer without padding the utterance,
+	// which ends once the silence threshold has passed.
+	for range 5 {
+		send(opusSilenceFrame)
+	}
+	expectNoSegment(t, out)
+	clock.Advance(time.Second - time.Millisecond)
+	expectNoSegment(t, out)
+	clock.Advance(time.Millisecond)
+	expectSegment(t, out, 5*frameSamples)
+	expectNoSegment(t, out)
+
+	// Speech resuming within the threshold after a DTX packet continues the
+	// same utterance.
+	for _, opus := range frames[5:7] {
+		send(opus)
+	}
+	send([]byte{0xF8})
+	clock.Advance(500 * time.Millisecond)
+	expectNoSegment(t, out)
+	send(frames[7])
+	send([]byte{0xF8})
+	clock.Advance(time.Second)
+	expectSegment(t, out, 3*frameSamples)
+
+	stats := r.LossStats()[7]
+	if stats.Silence != 7 || stats.Lost != 0 {
+		t.Fatalf("unexpected loss stats %+v", stats)
+	}
+}
+
+func TestReceiverStartFailureClosesSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mix.wav")
+	mixer, err := NewMixer(MixerOptions{Path: path, Format: FormatWAV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, _, _ := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp})
+	r := NewReceiver(seg, staticResolver{}, ReceiverOptions{Mixer: mixer})
+	r.logger = log.New(io.Discard, "", 0)
+
+	// The connection never exposes OpusRecv.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Start(ctx, &discordgo.VoiceConnection{})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Fatalf("mix header not finalized: RIFF size %#x", size)
+	}
+}
@@ -0,0 +1,294 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// recordChannels is written to OpusHead. Discord sends stereo Opus, and a
+	// stereo decoder also plays mono packets, so tracks are always declared stereo.
+	recordChannels = 2
+	// recordMaxGapSlack bounds silence filled from RTP timestamps to the
+	// wall-clock time since the previous packet plus this slack, so a bogus
+	// timestamp jump cannot inflate the track.
+	recordMaxGapSlack = time.Second
+)
+
+// opusSilenceFrame is a 20ms CELT packet that decodes to silence, used to fill
+// gaps between received packets without re-encoding. Discord clients send it,
+// usually five times in a row, when they stop transmitting.
+var opusSilenceFrame = []byte{0xf8, 0xff, 0xfe}
+
+// PacketRecorder receives every in-order Opus packet of users whose SSRC is
+// resolved. The Receiver closes it when it stops.
+type PacketRecorder interface {
+	RecordPacket(userID string, pkt *discordgo.Packet)
+	Close() error
+}
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	// Dir is the session directory; it is created if missing.
+	Dir       string
+	GuildID   string
+	ChannelID string
+	// Clock timestamps streams. Defaults to SystemClock.
+	Clock Clock
+	// DisplayName resolves user IDs for the metadata sidecars when the
+	// session ends. Optional.
+	DisplayName func(userID string) string
+}
+
+// TrackMetadata is the JSON sidecar written next to each user's track.
+type TrackMetadata struct {
+	GuildID      string           `json:"guild_id"`
+	ChannelID    string           `json:"channel_id"`
+	UserID       string           `json:"user_id"`
+	DisplayName  string           `json:"display_name,omitempty"`
+	File         string           `json:"file"`
+	SessionStart time.Time        `json:"session_start"`
+	DurationMS   int64            `json:"duration_ms"`
+	Streams      []StreamMetadata `json:"streams"`
+}
+
+// StreamMetadata describes one SSRC that contributed to a track. A user who
+// reconnects gets a new SSRC and RTP timeline, appended as another stream.
+type StreamMetadata struct {
+	SSRC uint32 `json:"ssrc"`
+	// RTPStart is the RTP timestamp of the stream's first recorded packet.
+	RTPStart  uint32    `json:"rtp_start"`
+	WallStart time.Time `json:"wall_start"`
+	// SessionOffsetMS is the wall-clock offset of the first packet from the session start.
+	SessionOffsetMS int64 `json:"session_offset_ms"`
+	// TrackOffsetMS is where the first packet sits in the track's timeline.
+	TrackOffsetMS int64 `json:"track_offset_ms"`
+}
+
+// Recorder writes one Ogg Opus track per user for a voice session, muxing the
+// received Opus packets as-is. Gaps in the RTP timeline are filled with Opus
+// silence frames so the tracks of a session stay aligned.
+type Recorder struct {
+	opts  RecorderOptions
+	start time.Time
+
+	mu     sync.Mutex
+	tracks map[string]*recordTrack
+	closed bool
+}
+
+type recordTrack struct {
+	meta TrackMetadata
+	path string
+	file *os.File
+	buf  *bufio.Writer
+	ogg  *oggWriter
+	err  error
+
+	written  int64 // 48kHz samples in the track
+	ssrc     uint32
+	lastRTP  uint32
+	rtpPos   int64 // track position of lastRTP
+	lastWall time.Time
+}
+
+// NewRecorder creates the session directory and returns a Recorder.
+func NewRecorder(opts RecorderOptions) (*Recorder, error) {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+	return &Recorder{
+		opts:   opts,
+		start:  opts.Clock.Now(),
+		tracks: make(map[string]*recordTrack),
+	}, nil
+}
+
+// RecordPacket implements PacketRecorder.
+func (r *Recorder) RecordPacket(userID string, pkt *discordgo.Packet) {
+	if userID == "" || pkt == nil || len(pkt.Opus) == 0 {
+		return
+	}
+	now := r.opts.Clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	track := r.tracks[userID]
+	if track == nil {
+		var err error
+		if track, err = r.openTrack(userID); err != nil {
+			log.Printf("recorder: open track user=%s failed: %v", userID, err)
+			r.tracks[userID] = &recordTrack{err: err}
+			return
+		}
+		r.tracks[userID] = track
+	}
+	if track.err != nil {
+		return
+	}
+	if err := track.write(pkt, now, r.start); err != nil {
+		log.Printf("recorder: write track user=%s failed: %v", userID, err)
+		track.err = err
+	}
+}
+
+func (r *Recorder) openTrack(userID string) (*recordTrack, error) {
+	name := userID + ".opus"
+	path := filepath.Join(r.opts.Dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create track: %w", err)
+	}
+	buf := bufio.NewWriter(file)
+	track := &recordTrack{
+		meta: TrackMetadata{
+			GuildID:      r.opts.GuildID,
+			ChannelID:    r.opts.ChannelID,
+			UserID:       userID,
+			File:         name,
+			SessionStart: r.start,
+		},
+		path: path,
+		file: file,
+		buf:  buf,
+		ogg:  newOggWriter(buf, oggOpusSerial),
+	}
+	if err := track.ogg.writeHeaderPacket(opusHead(SampleRate, recordChannels)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := track.ogg.writeHeaderPacket(opusTags()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return track, nil
+}
+
+func (t *recordTrack) write(pkt *discordgo.Packet, now, sessionStart time.Time) error {
+	duration := opusPacketSamples(pkt.Opus)
+	if duration == 0 {
+		return nil
+	}
+
+	if len(t.meta.Streams) == 0 || pkt.SSRC != t.ssrc {
+		// A new RTP timeline starts where the wall clock says it should.
+		pos := t.written
+		if len(t.meta.Streams) > 0 {
+			pos = max(pos, t.rtpPos+durationSamples48(now.Sub(t.lastWall)))
+		}
+		t.ssrc, t.lastRTP, t.rtpPos = pkt.SSRC, pkt.Timestamp, pos
+		t.meta.Streams = append(t.meta.Streams, StreamMetadata{
+			SSRC:            pkt.SSRC,
+			RTPStart:        pkt.Timestamp,
+			WallStart:       now,
+			SessionOffsetMS: now.Sub(sessionStart).Milliseconds(),
+			TrackOffsetMS:   pos * 1000 / SampleRate,
+		})
+	} else {
+		delta := int64(int32(pkt.Timestamp - t.lastRTP))
+		maxDelta := durationSamples48(now.Sub(t.lastWall) + recordMaxGapSlack)
+		t.rtpPos += min(delta, maxDelta)
+		t.lastRTP = pkt.Timestamp
+	}
+	t.lastWall = now
+
+	if t.rtpPos+int64(duration) <= t.written {
+		return nil // already covered, e.g. a retransmitted packet
+	}
+	for t.rtpPos-t.written >= frameSamples {
+		if err := t.writePacket(opusSilenceFrame, frameSamples); err != nil {
+			return err
+		}
+	}
+	return t.writePacket(pkt.Opus, duration)
+}
+
+func (t *recordTrack) writePacket(packet []byte, duration int) error {
+	t.written += int64(duration)
+	return t.ogg.writePacket(packet, opusPreSkip+t.written)
+}
+
+func (t *recordTrack) close() error {
+	if t.file == nil {
+		return t.err
+	}
+	err := t.err
+	if err == nil {
+		err = t.ogg.close(opusPreSkip + t.written)
+	}
+	if ferr := t.buf.Flush(); err == nil && ferr != nil {
+		err = fmt.Errorf("flush track: %w", ferr)
+	}
+	if cerr := t.file.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("close track: %w", cerr)
+	}
+	t.meta.DurationMS = t.written * 1000 / SampleRate
+	return err
+}
+
+// Close finalises every track and writes its metadata sidecar.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	tracks := make([]*recordTrack, 0, len(r.tracks))
+	for _, track := range r.tracks {
+		tracks = append(tracks, track)
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, track := range tracks {
+		if err := track.close(); err != nil {
+			errs = append(errs, err)
+		}
+		if track.file == nil {
+			continue
+		}
+		if r.opts.DisplayName != nil {
+			track.meta.DisplayName = r.opts.DisplayName(track.meta.UserID)
+		}
+		if err := writeTrackMetadata(track.path, track.meta); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func writeTrackMetadata(trackPath string, meta TrackMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode track metadata: %w", err)
+	}
+	path := trackPath[:len(trackPath)-len(filepath.Ext(trackPath))] + ".json"
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write track metadata: %w", err)
+	}
+	return nil
+}
+
+// durationSamples48 converts a duration into a 48kHz per-channel sample count.
+func durationSamples48(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return int64(d) * SampleRate / int64(time.Second)
+}
@@ -0,0 +1,114 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRecorderWritesTracksAndSidecars(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1000, 0))
+	rec, err := NewRecorder(RecorderOptions{
+		Dir:         dir,
+		GuildID:     "g",
+		ChannelID:   "c",
+		Clock:       clock,
+		DisplayName: func(userID string) string { return "name-" + userID },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := encodeSineFrames(t, 4)
+
+	clock.Advance(2 * time.Second)
+	send := func(ssrc uint32, seq int, ts uint32, opus []byte) {
+		rec.RecordPacket("alice", &discordgo.Packet{SSRC: ssrc, Sequence: uint16(seq), Timestamp: ts, Opus: opus})
+	}
+	send(7, 0, 5000, frames[0])
+	clock.Advance(frameDuration)
+	send(7, 1, 5000+frameSamples, frames[1])
+	// Half a second of silence without packets.
+	clock.Advance(500 * time.Millisecond)
+	send(7, 2, 5000+frameSamples*27, frames[2])
+	// The user reconnects with a new SSRC and unrelated RTP timeline.
+	clock.Advance(time.Second)
+	send(9, 0, 123, frames[3])
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "alice.opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := readOggPages(t, data)
+	var packets [][]byte
+	for _, p := range pages[2:] {
+		packets = append(packets, p.packets...)
+	}
+	// 25 silence frames fill the RTP gap on the first timeline, so the third
+	// packet ends at 560ms. The reconnect is placed by wall clock 1s after the
+	// third packet started, at 1540ms.
+	wantSilence := 25 + (1540-560)/20
+	if len(packets) != 4+wantSilence {
+		t.Fatalf("expected %d packets, got %d", 4+wantSilence, len(packets))
+	}
+	for i, p := range packets[2 : 2+25] {
+		if !bytes.Equal(p, opusSilenceFrame) {
+			t.Fatalf("packet %d is not a silence frame", i+2)
+		}
+	}
+	if !bytes.Equal(packets[27], frames[2]) || !bytes.Equal(packets[len(packets)-1], frames[3]) {
+		t.Fatalf("received packets must be muxed unchanged")
+	}
+	if last := pages[len(pages)-1]; last.granule != int64(opusPreSkip+len(packets)*frameSamples) {
+		t.Fatalf("unexpected final granule %d", last.granule)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "alice.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta TrackMetadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.UserID != "alice" || meta.DisplayName != "name-alice" || meta.File != "alice.opus" || meta.GuildID != "g" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if len(meta.Streams) != 2 {
+		t.Fatalf("expected two streams, got %+v", meta.Streams)
+	}
+	first, second := meta.Streams[0], meta.Streams[1]
+	if first.SSRC != 7 || first.RTPStart != 5000 || first.SessionOffsetMS != 2000 || first.TrackOffsetMS != 0 {
+		t.Fatalf("unexpected first stream %+v", first)
+	}
+	if second.SSRC != 9 || second.RTPStart != 123 || second.SessionOffsetMS != 3520 || second.TrackOffsetMS != 1540 {
+		t.Fatalf("unexpected second stream %+v", second)
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	cases := []struct {
+		packet []byte
+		want   int
+	}{
+		{[]byte{0xf8, 0xff, 0xfe}, 960},        // CELT FB 20ms, one frame
+		{[]byte{0x08 << 3}, 480},               // SILK WB 10ms
+		{[]byte{0x0b<<3 | 0x1}, 2 * 2880},      // SILK WB 60ms, two frames
+		{[]byte{0x0d<<3 | 0x3, 0x03}, 3 * 960}, // Hybrid SWB 20ms, three frames
+		{[]byte{0x10 << 3}, 120},               // CELT NB 2.5ms
+		{nil, 0},
+	}
+	for _, tc := range cases {
+		if got := opusPacketSamples(tc.packet); got != tc.want {
+			t.Errorf("opusPacketSamples(% x) = %d, want %d", tc.packet, got, tc.want)
+		}
+	}
+}
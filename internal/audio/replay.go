@@ -0,0 +1,133 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ReplayOptions configures ReplayCapture. They should match the live
+// settings of the session the capture came from.
+type ReplayOptions struct {
+	// Segmenter options; Clock is replaced by the replay clock and Channels
+	// follows the receiver's layout.
+	Segmenter SegmenterOptions
+	Receiver  ReceiverOptions
+	// Logger receives the receiver's logs. Defaults to log.Default().
+	Logger *log.Logger
+}
+
+// ReplayCapture pushes a capture through a Receiver and Segmenter on a
+// ManualClock that follows the recorded arrival times, including the jitter
+// buffer's playout ticks, so the same capture always yields the same
+// segments. It returns once every segment has been handled by consumer.
+//
+// Unlike a live session, audio of an SSRC that never gets mapped is dropped
+// at the end of the replay rather than after a timeout.
+func ReplayCapture(cr *CaptureReader, opts ReplayOptions, consumer SegmentConsumer) error {
+	start := cr.Start.Time
+	clock := NewManualClock(start)
+	opts.Segmenter.Clock = clock
+	opts.Segmenter.Channels = opts.Receiver.Layout.Channels()
+	segmenter := NewSegmenter(cr.Start.GuildID, opts.Segmenter, consumer)
+	resolver := newReplayResolver()
+	r := NewReceiver(segmenter, resolver, opts.Receiver)
+	if opts.Logger != nil {
+		r.logger = opts.Logger
+	}
+
+	tick := start.Add(jitterTickInterval)
+	var err error
+	for {
+		var ev CaptureEvent
+		ev, err = cr.Next()
+		if err != nil {
+			break
+		}
+		for !tick.After(ev.Time) {
+			clock.AdvanceTo(tick)
+			r.drainStreams(tick)
+			tick = tick.Add(jitterTickInterval)
+		}
+		clock.AdvanceTo(ev.Time)
+		replayEvent(r, resolver, ev)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	r.shutdown()
+	resolver.close()
+	return err
+}
+
+// replayEvent applies ev in the order the live handlers run: the bot's
+// resolver first, then the receiver.
+func replayEvent(r *Receiver, resolver *replayResolver, ev CaptureEvent) {
+	switch ev.Kind {
+	case CapturePacket:
+		r.handlePacket(&discordgo.Packet{
+			SSRC:      ev.SSRC,
+			UserID:    ev.UserID,
+			Sequence:  ev.Sequence,
+			Timestamp: ev.Timestamp,
+			Opus:      ev.Opus,
+		}, ev.Time)
+	case CaptureMapping:
+		resolver.set(ev.SSRC, ev.UserID)
+		r.RemapSSRC(ev.SSRC, ev.UserID)
+		r.deliverPending(ev.SSRC, ev.UserID)
+	case CaptureSpeaking:
+		r.segmenter.SetSpeaking(ev.UserID, ev.Speaking)
+	case CaptureDisconnect:
+		r.RemoveUser(ev.UserID, ev.SSRCs)
+		resolver.remove(ev.SSRCs)
+	}
+}
+
+// replayResolver resolves SSRCs from the capture's mapping events. Pending
+// audio is delivered synchronously by the replay loop, so Wait only returns
+// once the replay is over.
+type replayResolver struct {
+	mu    sync.Mutex
+	users map[uint32]string
+	done  chan struct{}
+}
+
+func newReplayResolver() *replayResolver {
+	return &replayResolver{users: make(map[uint32]string), done: make(chan struct{})}
+}
+
+func (r *replayResolver) Resolve(ssrc uint32) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	userID, ok := r.users[ssrc]
+	return userID, ok
+}
+
+func (r *replayResolver) Wait(uint32, time.Duration) (string, bool) {
+	<-r.done
+	return "", false
+}
+
+func (r *replayResolver) set(ssrc uint32, userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[ssrc] = userID
+}
+
+func (r *replayResolver) remove(ssrcs []uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ssrc := range ssrcs {
+		delete(r.users, ssrc)
+	}
+}
+
+func (r *replayResolver) close() {
+	close(r.done)
+}
@@ -0,0 +1,152 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// resampleAttenuationDB is the stopband attenuation of the anti-aliasing filter.
+	resampleAttenuationDB = 80.0
+	// resamplePassband is the fraction of the lower Nyquist frequency kept flat.
+	resamplePassband = 0.9
+)
+
+// Resampler converts interleaved PCM16 between sample rates using a polyphase
+// Kaiser-windowed sinc filter, which doubles as the anti-aliasing low-pass
+// when downsampling. It holds no per-stream state and is safe for concurrent use.
+type Resampler struct {
+	inRate   int
+	outRate  int
+	channels int
+	up       int // interpolation factor L
+	down     int // decimation factor M
+	half     int // filter half width in input samples
+	phases   [][]float64
+}
+
+// NewResampler builds a resampler from inRate to outRate for the given channel count.
+func NewResampler(inRate, outRate, channels int) (*Resampler, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return nil, fmt.Errorf("sample rates must be positive")
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("channels must be positive")
+	}
+	g := gcd(inRate, outRate)
+	r := &Resampler{
+		inRate:   inRate,
+		outRate:  outRate,
+		channels: channels,
+		up:       outRate / g,
+		down:     inRate / g,
+	}
+	if r.up == r.down {
+		return r, nil
+	}
+
+	// Frequencies are normalised to cycles per input sample.
+	nyquist := 0.5 * math.Min(1, float64(outRate)/float64(inRate))
+	passband := resamplePassband * nyquist
+	cutoff := (passband + nyquist) / 2
+	transition := nyquist - passband
+
+	length := (resampleAttenuationDB - 8) / (2.285 * 2 * math.Pi * transition)
+	r.half = int(math.Ceil(length / 2))
+	beta := 0.1102 * (resampleAttenuationDB - 8.7)
+	norm := besselI0(beta)
+
+	r.phases = make([][]float64, r.up)
+	for p := 0; p < r.up; p++ {
+		frac := float64(p) / float64(r.up)
+		taps := make([]float64, 2*r.half)
+		for j := range taps {
+			// Tap j weights input sample (base - half + 1 + j) for an output at base + frac.
+			t := frac + float64(r.half-1-j)
+			x := t / float64(r.half)
+			if x <= -1 || x >= 1 {
+				continue
+			}
+			window := besselI0(beta*math.Sqrt(1-x*x)) / norm
+			taps[j] = 2 * cutoff * sinc(2*cutoff*t) * window
+		}
+		r.phases[p] = taps
+	}
+	return r, nil
+}
+
+// OutputRate returns the sample rate produced by Resample.
+func (r *Resampler) OutputRate() int {
+	return r.outRate
+}
+
+// Resample converts a complete interleaved buffer. Samples beyond either end
+// of the input are treated as silence.
+func (r *Resampler) Resample(samples []int16) []int16 {
+	if r.up == r.down {
+		out := make([]int16, len(samples))
+		copy(out, samples)
+		return out
+	}
+	inFrames := len(samples) / r.channels
+	outFrames := (inFrames*r.up + r.down - 1) / r.down
+	out := make([]int16, outFrames*r.channels)
+
+	for n := 0; n < outFrames; n++ {
+		pos := n * r.down
+		base := pos / r.up
+		taps := r.phases[pos%r.up]
+		first := base - r.half + 1
+		for c := 0; c < r.channels; c++ {
+			var acc float64
+			for j, tap := range taps {
+				k := first + j
+				if k < 0 || k >= inFrames {
+					continue
+				}
+				acc += tap * float64(samples[k*r.channels+c])
+			}
+			out[n*r.channels+c] = clampInt16(acc)
+		}
+	}
+	return out
+}
+
+func clampInt16(v float64) int16 {
+	v = math.Round(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 evaluates the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	half := x / 2
+	for k := 1; k < 50; k++ {
+		term *= half / float64(k)
+		sum += term * term
+		if term*term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+func sineSamples(freq float64, rate, n int, amp float64) []int16 {
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(amp * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return out
+}
+
+// toneGainDB measures the amplitude of the freq component of samples relative
+// to amp, ignoring edge samples affected by the filter run-in.
+func toneGainDB(samples []int16, freq float64, rate int, amp float64, edge int) float64 {
+	body := samples[edge : len(samples)-edge]
+	var re, im float64
+	for i, v := range body {
+		phase := 2 * math.Pi * freq * float64(i+edge) / float64(rate)
+		re += float64(v) * math.Cos(phase)
+		im += float64(v) * math.Sin(phase)
+	}
+	got := 2 * math.Hypot(re, im) / float64(len(body))
+	return 20 * math.Log10(got/amp)
+}
+
+// rmsGainDB measures the total output power relative to a full sine of amplitude amp.
+func rmsGainDB(samples []int16, amp float64, edge int) float64 {
+	body := samples[edge : len(samples)-edge]
+	var sum float64
+	for _, v := range body {
+		sum += float64(v) * float64(v)
+	}
+	rms := math.Sqrt(sum / float64(len(body)))
+	return 20 * math.Log10(rms/(amp/math.Sqrt2))
+}
+
+func TestResamplerDownsamplePassband(t *testing.T) {
+	r, err := NewResampler(48000, 16000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const amp = 16000.0
+	for _, freq := range []float64{100, 1000, 3000, 7000} {
+		out := r.Resample(sineSamples(freq, 48000, 48000, amp))
+		if len(out) != 16000 {
+			t.Fatalf("expected 16000 samples, got %d", len(out))
+		}
+		if gain := toneGainDB(out, freq, 16000, amp, 200); math.Abs(gain) > 0.1 {
+			t.Errorf("%.0f Hz: passband gain %.3f dB, want within 0.1 dB", freq, gain)
+		}
+	}
+}
+
+func TestResamplerDownsampleStopband(t *testing.T) {
+	r, err := NewResampler(48000, 16000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const amp = 16000.0
+	for _, freq := range []float64{8500, 10000, 14000, 20000} {
+		out := r.Resample(sineSamples(freq, 48000, 48000, amp))
+		if gain := rmsGainDB(out, amp, 200); gain > -70 {
+			t.Errorf("%.0f Hz: aliased output at %.1f dB, want below -70 dB", freq, gain)
+		}
+	}
+}
+
+func TestResamplerUpsampleAndFractionalRatio(t *testing.T) {
+	const amp = 12000.0
+	cases := []struct {
+		in, out int
+		freq    float64
+	}{
+		{16000, 48000, 1000},
+		{48000, 44100, 5000},
+		{48000, 22050, 2500},
+	}
+	for _, tc := range cases {
+		r, err := NewResampler(tc.in, tc.out, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := r.Resample(sineSamples(tc.freq, tc.in, tc.in, amp))
+		if len(out) != tc.out {
+			t.Fatalf("%d->%d: expected %d samples, got %d", tc.in, tc.out, tc.out, len(out))
+		}
+		if gain := toneGainDB(out, tc.freq, tc.out, amp, tc.out/50); math.Abs(gain) > 0.1 {
+			t.Errorf("%d->%d: %.0f Hz gain %.3f dB, want within 0.1 dB", tc.in, tc.out, tc.freq, gain)
+		}
+	}
+}
+
+func TestResamplerInterleavedChannels(t *testing.T) {
+	r, err := NewResampler(48000, 16000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := sineSamples(1000, 48000, 4800, 10000)
+	in := make([]int16, 2*len(left))
+	for i, v := range left {
+		in[2*i] = v
+	}
+	out := r.Resample(in)
+	if len(out) != 2*1600 {
+		t.Fatalf("expected %d samples, got %d", 2*1600, len(out))
+	}
+	for i := 1; i < len(out); i += 2 {
+		if out[i] != 0 {
+			t.Fatalf("silent right channel picked up %d at frame %d", out[i], i/2)
+		}
+	}
+}
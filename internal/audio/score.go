@@ -0,0 +1,187 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// scoreFrame is the analysis window for voicing and spectral flatness. It
+	// is long enough to hold two periods of a 70Hz voice.
+	scoreFrame = 40 * time.Millisecond
+	// scoreActiveDBFS is the level below which frames are treated as silence
+	// and ignored by the voicing and flatness measures.
+	scoreActiveDBFS = -55.0
+	// scorePitchRate is the rate frames are decimated to for pitch detection.
+	scorePitchRate = 8000
+	scoreMinPitch  = 70.0
+	scoreMaxPitch  = 400.0
+	// scoreVoicedCorrelation is the normalised autocorrelation at the pitch
+	// lag above which a frame counts as voiced.
+	scoreVoicedCorrelation = 0.6
+	// Spectral flatness is measured over the band that carries speech formants.
+	scoreFlatnessLowHz  = 100.0
+	scoreFlatnessHighHz = 4000.0
+)
+
+// SegmentScore describes how speech-like a segment is.
+type SegmentScore struct {
+	Duration time.Duration
+	// RMSDBFS is the RMS level of the whole segment.
+	RMSDBFS float64
+	// VoicedFraction is the share of analysis frames with a clear pitch.
+	VoicedFraction float64
+	// SpectralFlatness is the mean ratio of the geometric to the arithmetic
+	// mean of the power spectrum over non-silent frames: near 0 for tonal
+	// sounds such as vowels, about 0.5 for white noise such as breathing.
+	SpectralFlatness float64
+	// PeakToAverageDB is the ratio of the peak to the RMS level. Isolated
+	// clicks score far higher than speech.
+	PeakToAverageDB float64
+}
+
+// String formats the score for logs.
+func (s SegmentScore) String() string {
+	return fmt.Sprintf("duration=%.2fs rms=%.1fdBFS voiced=%.2f flatness=%.2f par=%.1fdB",
+		s.Duration.Seconds(), s.RMSDBFS, s.VoicedFraction, s.SpectralFlatness, s.PeakToAverageDB)
+}
+
+// ScoreThresholds decides which segments are worth transcribing.
+type ScoreThresholds struct {
+	MinDuration         time.Duration
+	MinRMSDBFS          float64
+	MinVoicedFraction   float64
+	MaxSpectralFlatness float64
+	MaxPeakToAverageDB  float64
+}
+
+// Default segment score thresholds.
+const (
+	DefaultMinSegmentDuration  = 250 * time.Millisecond
+	DefaultMinRMSDBFS          = -50.0
+	DefaultMinVoicedFraction   = 0.15
+	DefaultMaxSpectralFlatness = 0.4
+	DefaultMaxPeakToAverageDB  = 25.0
+)
+
+// DefaultScoreThresholds returns thresholds that keep quiet speech but reject
+// clicks, breathing and other non-speech noise.
+func DefaultScoreThresholds() ScoreThresholds {
+	return ScoreThresholds{
+		MinDuration:         DefaultMinSegmentDuration,
+		MinRMSDBFS:          DefaultMinRMSDBFS,
+		MinVoicedFraction:   DefaultMinVoicedFraction,
+		MaxSpectralFlatness: DefaultMaxSpectralFlatness,
+		MaxPeakToAverageDB:  DefaultMaxPeakToAverageDB,
+	}
+}
+
+// Accept reports whether a segment with the given score should be
+// transcribed, and if not, which threshold rejected it.
+func (t ScoreThresholds) Accept(s SegmentScore) (bool, string) {
+	switch {
+	case s.Duration < t.MinDuration:
+		return false, fmt.Sprintf("duration %.2fs < %.2fs", s.Duration.Seconds(), t.MinDuration.Seconds())
+	case s.RMSDBFS < t.MinRMSDBFS:
+		return false, fmt.Sprintf("rms %.1fdBFS < %.1fdBFS", s.RMSDBFS, t.MinRMSDBFS)
+	case s.VoicedFraction < t.MinVoicedFraction:
+		return false, fmt.Sprintf("voiced fraction %.2f < %.2f", s.VoicedFraction, t.MinVoicedFraction)
+	case s.SpectralFlatness > t.MaxSpectralFlatness:
+		return false, fmt.Sprintf("spectral flatness %.2f > %.2f", s.SpectralFlatness, t.MaxSpectralFlatness)
+	case s.PeakToAverageDB > t.MaxPeakToAverageDB:
+		return false, fmt.Sprintf("peak to average %.1fdB > %.1fdB", s.PeakToAverageDB, t.MaxPeakToAverageDB)
+	}
+	return true, ""
+}
+
+// ScoreSegment measures mono PCM16 samples at sampleRate.
+func ScoreSegment(samples []int16, sampleRate int) SegmentScore {
+	score := SegmentScore{
+		Duration:         time.Duration(len(samples)) * time.Second / time.Duration(sampleRate),
+		RMSDBFS:          frameDBFS(samples),
+		SpectralFlatness: 1,
+	}
+	if len(samples) == 0 {
+		return score
+	}
+
+	var peak float64
+	for _, v := range samples {
+		peak = max(peak, math.Abs(float64(v)))
+	}
+	if peak > 0 {
+		score.PeakToAverageDB = 20*math.Log10(peak/32768) - score.RMSDBFS
+	}
+
+	size := int(scoreFrame * time.Duration(sampleRate) / time.Second)
+	var frames, voiced, active int
+	var flatness float64
+	for start := 0; start+size <= len(samples); start += size {
+		frame := samples[start : start+size]
+		frames++
+		if frameDBFS(frame) < scoreActiveDBFS {
+			continue
+		}
+		active++
+		flatness += spectralFlatness(frame, sampleRate)
+		if isVoiced(frame, sampleRate) {
+			voiced++
+		}
+	}
+	if frames > 0 {
+		score.VoicedFraction = float64(voiced) / float64(frames)
+	}
+	if active > 0 {
+		score.SpectralFlatness = flatness / float64(active)
+	}
+	return score
+}
+
+// isVoiced reports whether frame has a strong periodicity in the range of
+// human pitch. The frame is decimated first to keep the autocorrelation cheap.
+func isVoiced(frame []int16, sampleRate int) bool {
+	factor := max(1, sampleRate/scorePitchRate)
+	rate := sampleRate / factor
+	x := make([]float64, len(frame)/factor)
+	for i := range x {
+		var sum float64
+		for _, v := range frame[i*factor : (i+1)*factor] {
+			sum += float64(v)
+		}
+		x[i] = sum / float64(factor)
+	}
+
+	minLag := int(float64(rate) / scoreMaxPitch)
+	maxLag := min(int(float64(rate)/scoreMinPitch), len(x)/2)
+	for lag := minLag; lag <= maxLag; lag++ {
+		var xy, xx, yy float64
+		for i := 0; i+lag < len(x); i++ {
+			a, b := x[i], x[i+lag]
+			xy += a * b
+			xx += a * a
+			yy += b * b
+		}
+		if xx > 0 && yy > 0 && xy/math.Sqrt(xx*yy) >= scoreVoicedCorrelation {
+			return true
+		}
+	}
+	return false
+}
+
+// spectralFlatness returns the Wiener entropy of frame's power spectrum
+// within the speech band.
+func spectralFlatness(frame []int16, sampleRate int) float64 {
+	spectrum := powerSpectrum(frame)
+	binHz := float64(sampleRate) / float64(2*(len(spectrum)-1))
+	lo := max(1, int(scoreFlatnessLowHz/binHz))
+	hi := min(len(spectrum)-1, int(scoreFlatnessHighHz/binHz))
+	var logSum, sum float64
+	for _, p := range spectrum[lo : hi+1] {
+		p += 1e-20
+		logSum += math.Log(p)
+		sum += p
+	}
+	n := float64(hi - lo + 1)
+	return math.Exp(logSum/n) / (sum / n)
+}
@@ -0,0 +1,76 @@
+package audio
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScoreSegmentFixtures(t *testing.T) {
+	cases := []struct {
+		file   string
+		accept bool
+		reason string
+	}{
+		{"speech.wav", true, ""},
+		// Rejected by the old mean absolute amplitude rule.
+		{"quiet_speech.wav", true, ""},
+		{"short_speech.wav", false, "duration"},
+		{"keyboard.wav", false, "voiced fraction"},
+		{"breathing.wav", false, "voiced fraction"},
+	}
+	thresholds := DefaultScoreThresholds()
+	for _, tc := range cases {
+		wav, err := ReadWAVFile(filepath.Join("testdata", "score", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		score := ScoreSegment(wav.Samples, wav.SampleRate)
+		ok, reason := thresholds.Accept(score)
+		t.Logf("%s: %s", tc.file, score)
+		if ok != tc.accept || !strings.HasPrefix(reason, tc.reason) {
+			t.Errorf("%s: Accept = %t %q, want %t %q (%s)", tc.file, ok, reason, tc.accept, tc.reason, score)
+		}
+	}
+}
+
+func TestScoreSegmentMeasures(t *testing.T) {
+	rate := 16000
+	tone := sineSamples(200, rate, rate, 16384)
+	score := ScoreSegment(tone, rate)
+	if score.RMSDBFS < -9.1 || score.RMSDBFS > -8.9 {
+		t.Errorf("expected a -6dBFS sine to measure -9dBFS RMS, got %.2f", score.RMSDBFS)
+	}
+	if score.PeakToAverageDB < 2.9 || score.PeakToAverageDB > 3.1 {
+		t.Errorf("expected a sine's peak to average ratio of 3dB, got %.2f", score.PeakToAverageDB)
+	}
+	if score.VoicedFraction < 0.95 {
+		t.Errorf("expected a 200Hz tone to be voiced, got %.2f", score.VoicedFraction)
+	}
+	if score.SpectralFlatness > 0.05 {
+		t.Errorf("expected a pure tone to have low flatness, got %.2f", score.SpectralFlatness)
+	}
+
+	silence := ScoreSegment(make([]int16, rate), rate)
+	if ok, reason := DefaultScoreThresholds().Accept(silence); ok || !strings.HasPrefix(reason, "rms") {
+		t.Errorf("expected silence to be rejected by level, got %t %q", ok, reason)
+	}
+}
+
+func TestScoreThresholdsRejectClicksAndNoise(t *testing.T) {
+	th := DefaultScoreThresholds()
+	base := SegmentScore{Duration: th.MinDuration, RMSDBFS: -30, VoicedFraction: 0.5, SpectralFlatness: 0.1, PeakToAverageDB: 12}
+	if ok, reason := th.Accept(base); !ok {
+		t.Fatalf("expected base score to pass, got %q", reason)
+	}
+	flat := base
+	flat.SpectralFlatness = 0.6
+	if ok, reason := th.Accept(flat); ok || !strings.HasPrefix(reason, "spectral flatness") {
+		t.Errorf("expected flat spectrum to be rejected, got %t %q", ok, reason)
+	}
+	spiky := base
+	spiky.PeakToAverageDB = 30
+	if ok, reason := th.Accept(spiky); ok || !strings.HasPrefix(reason, "peak to average") {
+		t.Errorf("expected spiky signal to be rejected, got %t %q", ok, reason)
+	}
+}
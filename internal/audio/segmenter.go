@@ -1,40 +1,194 @@
 package audio
 
 import (
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// idleTimeoutSlack is added to the silence threshold in timestamp mode so that
+// network jitter does not let the idle timer pre-empt RTP gap detection.
+const idleTimeoutSlack = 500 * time.Millisecond
+
+// maxLateFrame is how far before the buffered audio a frame may start and
+// still be taken as late rather than as a new stream: the receiver holds at
+// most maxPendingDuration of audio for an unmapped SSRC.
+const maxLateFrame = maxPendingDuration
+
+// utteranceSeq hands out process-wide unique utterance IDs.
+var utteranceSeq atomic.Uint64
+
+// Segment is a chunk of a user's audio handed to the SegmentConsumer.
+type Segment struct {
+	GuildID string
+	UserID  string
+	// UtteranceID identifies the utterance. Interim snapshots and the final
+	// segment of the same utterance share it.
+	UtteranceID uint64
+	// Interim marks a provisional snapshot of an utterance still in progress.
+	// Its Samples cover the utterance from its start up to the snapshot.
+	Interim bool
+	Samples []int16
+	// Channels is the number of interleaved channels in Samples.
+	Channels int
+	// Lead and Tail are the samples of pre-roll and post-roll padding at
+	// the start and end of Samples.
+	Lead, Tail int
+	// Merged lists the utterances a SegmentQueue appended to this segment
+	// under OverflowMerge, in order.
+	Merged []uint64
+}
+
+// Speech returns Samples without the pre-roll and post-roll padding.
+func (s Segment) Speech() []int16 {
+	return s.Samples[s.Lead : len(s.Samples)-s.Tail]
+}
+
 // SegmentConsumer is invoked when a user's audio segment is ready to process.
-type SegmentConsumer func(guildID, userID string, samples []int16)
+type SegmentConsumer func(Segment)
+
+// SegmentMode selects how utterance boundaries are detected.
+type SegmentMode int
+
+const (
+	// SegmentByTimestamp splits on gaps between the RTP timestamps of consecutive
+	// frames and only uses the clock to close utterances once packets stop.
+	SegmentByTimestamp SegmentMode = iota
+	// SegmentByArrival splits when no frame has arrived for the silence threshold.
+	SegmentByArrival
+)
+
+// ParseSegmentMode converts a configuration string into a SegmentMode.
+func ParseSegmentMode(s string) (SegmentMode, bool) {
+	switch s {
+	case "timestamp", "rtp":
+		return SegmentByTimestamp, true
+	case "arrival":
+		return SegmentByArrival, true
+	}
+	return 0, false
+}
+
+// SegmenterOptions configures a Segmenter.
+type SegmenterOptions struct {
+	// Silence is the gap that ends an utterance.
+	Silence time.Duration
+	// Mode selects the boundary detection strategy.
+	Mode SegmentMode
+	// IdleTimeout flushes a user's buffer when no frame arrives for this long.
+	// Defaults to Silence in arrival mode and Silence plus a small slack in timestamp mode.
+	IdleTimeout time.Duration
+	// Clock drives the idle timers. Defaults to SystemClock.
+	Clock Clock
+	// Channels is the number of interleaved channels in frames, normally
+	// the receiver's ChannelLayout.Channels(). Defaults to 1.
+	Channels int
+	// VAD creates a per-user voice activity detector consulted for every 20ms
+	// frame, downmixed to mono. When nil, every received frame counts as speech.
+	VAD VADFactory
+	// Filters creates a per-user, per-channel preprocessing chain applied to
+	// every frame before voice activity detection. When nil, frames are used
+	// as received.
+	Filters FilterFactory
+	// MaxSegment caps the length of a single segment. When reached, the buffer is
+	// split at the quietest frame within SplitWindow before the limit. Zero disables it.
+	MaxSegment time.Duration
+	// SplitWindow is how far back from MaxSegment the split point is searched.
+	// Defaults to a quarter of MaxSegment.
+	SplitWindow time.Duration
+	// InterimInterval emits an interim snapshot of an in-progress utterance each
+	// time this much new audio has accumulated. Zero disables interim snapshots.
+	InterimInterval time.Duration
+	// SpeakingGrace closes a user's utterance this long after Discord reports
+	// they stopped speaking, unless they resume first. It should exceed the
+	// jitter buffer delay so trailing packets are included. Zero ignores
+	// speaking updates and relies on the silence threshold alone.
+	SpeakingGrace time.Duration
+	// PreRoll is prepended to every utterance so recognisers do not miss its
+	// first sound. It is taken from the non-speech frames the VAD dropped
+	// just before the utterance and topped up with comfort noise at their
+	// level. Zero disables it.
+	PreRoll time.Duration
+	// PostRoll is silence appended to final segments so the last word is
+	// not cut off. Zero disables it.
+	PostRoll time.Duration
+}
 
 // Segmenter groups PCM samples into per-user segments with a silence timeout.
 type Segmenter struct {
 	guildID  string
-	timeout  time.Duration
+	opts     SegmenterOptions
 	consumer SegmentConsumer
 
 	mu      sync.Mutex
 	buffers map[string]*userBuffer
+
+	// pending holds emitted segments not yet handed to the consumer, in
+	// emission order. delivering is set while a goroutine is delivering
+	// them and idle is signalled when it finishes.
+	pending    []Segment
+	delivering bool
+	idle       *sync.Cond
 }
 
 type userBuffer struct {
 	samples []int16
-	timer   *time.Timer
+	timer   Timer
+	// lastFrame is when the most recent frame arrived.
+	lastFrame time.Time
+	// speakingTimer closes the utterance once the grace period after a
+	// speaking stop elapses.
+	speakingTimer Timer
+
+	haveTimestamp bool
+	nextTimestamp uint32
+
+	filter   Filter
+	vad      VoiceActivityDetector
+	speech   bool // the buffer contains at least one speech frame
+	trailing int  // samples of non-speech at the end of the buffer
+
+	utterance uint64 // zero until the first segment of the utterance is emitted
+	snapshot  int    // buffer length at the last interim snapshot
+
+	preRoll []int16 // the most recent non-speech frames dropped before an utterance
+	lead    []int16 // pre-roll of the current utterance
+	noise   uint32  // comfort noise generator state
 }
 
-// NewSegmenter returns a new Segmenter.
-func NewSegmenter(guildID string, silence time.Duration, consumer SegmentConsumer) *Segmenter {
-	return &Segmenter{
+// NewSegmenter returns a new Segmenter. The consumer is called in emission
+// order on a separate goroutine without the Segmenter locked, so frames are
+// still accepted while it blocks; it should nevertheless hand slow work such
+// as transcription to a SegmentQueue.
+func NewSegmenter(guildID string, opts SegmenterOptions, consumer SegmentConsumer) *Segmenter {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	opts.Channels = max(opts.Channels, 1)
+	if opts.MaxSegment > 0 && (opts.SplitWindow <= 0 || opts.SplitWindow > opts.MaxSegment) {
+		opts.SplitWindow = opts.MaxSegment / 4
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = opts.Silence
+		if opts.Mode == SegmentByTimestamp {
+			opts.IdleTimeout += idleTimeoutSlack
+		}
+	}
+	s := &Segmenter{
 		guildID:  guildID,
-		timeout:  silence,
+		opts:     opts,
 		consumer: consumer,
 		buffers:  make(map[string]*userBuffer),
 	}
+	s.idle = sync.NewCond(&s.mu)
+	return s
 }
 
-// AddSamples appends new PCM samples for the given user and schedules flush on silence.
-func (s *Segmenter) AddSamples(userID string, samples []int16) {
+// AddFrame appends a decoded frame starting at the given 48kHz RTP timestamp
+// for the user and schedules a flush on silence.
+func (s *Segmenter) AddFrame(userID string, timestamp uint32, samples []int16) {
 	if len(samples) == 0 {
 		return
 	}
@@ -48,29 +202,205 @@ func (s *Segmenter) AddSamples(userID string, samples []int16) {
 		s.buffers[userID] = buf
 	}
 
-	buf.samples = append(buf.samples, samples...)
-	s.resetTimerLocked(userID, buf)
+	if s.opts.Filters != nil {
+		if buf.filter == nil {
+			filters := make(channelFilters, s.opts.Channels)
+			for ch := range filters {
+				filters[ch] = s.opts.Filters()
+			}
+			buf.filter = filters
+		}
+		samples = slices.Clone(samples)
+		buf.filter.Process(samples)
+	}
+
+	late := false
+	if s.opts.Mode == SegmentByTimestamp && buf.haveTimestamp {
+		switch gap := buf.timestampGap(timestamp); {
+		case gap < 0 && -gap <= maxLateFrame:
+			// Audio held back while the SSRC was unmapped can arrive after
+			// live frames; it is kept without being mistaken for a pause.
+			late = true
+		case len(buf.samples) > 0 && (gap >= s.opts.Silence || gap < 0):
+			s.flushLocked(userID, buf)
+		}
+	}
+
+	if s.opts.VAD != nil {
+		s.appendWithVADLocked(userID, buf, samples)
+	} else {
+		s.startUtteranceLocked(buf)
+		buf.samples = append(buf.samples, samples...)
+	}
+	s.splitLongLocked(userID, buf)
+	s.snapshotLocked(userID, buf)
+	if !late {
+		buf.nextTimestamp = timestamp + uint32(len(samples)/s.opts.Channels)
+		buf.haveTimestamp = true
+	}
+	buf.lastFrame = s.opts.Clock.Now()
+	s.resetTimerLocked(userID, buf, s.opts.IdleTimeout)
+}
+
+// SetVAD replaces the voice activity detector used for subsequent frames.
+func (s *Segmenter) SetVAD(factory VADFactory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts.VAD = factory
+	for _, buf := range s.buffers {
+		buf.vad = nil
+	}
+}
+
+// SetFilters replaces the preprocessing chain used for subsequent frames.
+func (s *Segmenter) SetFilters(factory FilterFactory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts.Filters = factory
+	for _, buf := range s.buffers {
+		buf.filter = nil
+	}
+}
+
+// appendWithVADLocked classifies samples frame by frame, dropping non-speech
+// before an utterance and flushing once trailing non-speech reaches the silence threshold.
+func (s *Segmenter) appendWithVADLocked(userID string, buf *userBuffer, samples []int16) {
+	if buf.vad == nil {
+		buf.vad = s.opts.VAD()
+	}
+	step := frameSamples * s.opts.Channels
+	for start := 0; start < len(samples); start += step {
+		end := start + step
+		if end > len(samples) {
+			end = len(samples)
+		}
+		frame := samples[start:end]
+		if buf.vad.IsSpeech(Downmix(frame, s.opts.Channels)) {
+			s.startUtteranceLocked(buf)
+			buf.samples = append(buf.samples, frame...)
+			buf.speech = true
+			buf.trailing = 0
+			continue
+		}
+		if !buf.speech {
+			s.keepPreRollLocked(buf, frame)
+			continue
+		}
+		buf.samples = append(buf.samples, frame...)
+		buf.trailing += len(frame)
+		if s.duration(buf.trailing) >= s.opts.Silence {
+			s.flushLocked(userID, buf)
+		}
+	}
+}
+
+// SetSpeaking applies a Discord speaking update for the user. When the flag
+// drops, the user's utterance is closed after SpeakingGrace unless speaking
+// resumes first. Users without buffered audio are ignored, so missing updates
+// leave segmentation to the silence threshold.
+func (s *Segmenter) SetSpeaking(userID string, speaking bool) {
+	if s.opts.SpeakingGrace <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := s.buffers[userID]
+	if buf == nil {
+		return
+	}
+	if buf.speakingTimer != nil {
+		buf.speakingTimer.Stop()
+		buf.speakingTimer = nil
+	}
+	if speaking {
+		return
+	}
+	var timer Timer
+	timer = s.opts.Clock.AfterFunc(s.opts.SpeakingGrace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.buffers[userID] != buf || buf.speakingTimer != timer {
+			return
+		}
+		buf.speakingTimer = nil
+		s.flushLocked(userID, buf)
+	})
+	buf.speakingTimer = timer
+}
+
+// EndOfSpeech notes that the user's client stopped sending audio, as
+// signalled by Opus silence or DTX frames. The utterance is closed once the
+// silence threshold has passed since the last frame, or earlier by
+// SpeakingGrace, unless the user resumes speaking first.
+func (s *Segmenter) EndOfSpeech(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf := s.buffers[userID]
+	if buf == nil || len(buf.samples) == 0 {
+		return
+	}
+	elapsed := s.opts.Clock.Now().Sub(buf.lastFrame)
+	s.resetTimerLocked(userID, buf, max(s.opts.Silence-elapsed, 0))
 }
 
-// Stop flushes all active buffers.
+// Stop flushes all active buffers and waits until the consumer has handled
+// every emitted segment.
 func (s *Segmenter) Stop() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for userID, buf := range s.buffers {
-		if buf.timer != nil {
-			buf.timer.Stop()
-		}
+		buf.stopTimers()
 		s.flushLocked(userID, buf)
 		delete(s.buffers, userID)
 	}
+	for s.delivering {
+		s.idle.Wait()
+	}
+}
+
+// RemoveUser flushes the user's buffered audio and forgets their state.
+func (s *Segmenter) RemoveUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, ok := s.buffers[userID]
+	if !ok {
+		return
+	}
+	buf.stopTimers()
+	s.flushLocked(userID, buf)
+	delete(s.buffers, userID)
+}
+
+func (buf *userBuffer) stopTimers() {
+	if buf.timer != nil {
+		buf.timer.Stop()
+	}
+	if buf.speakingTimer != nil {
+		buf.speakingTimer.Stop()
+	}
 }
 
-func (s *Segmenter) resetTimerLocked(userID string, buf *userBuffer) {
+// timestampGap returns how long after the end of the buffered audio the
+// frame at timestamp starts, negative for a frame from before it.
+func (buf *userBuffer) timestampGap(timestamp uint32) time.Duration {
+	return samplesDuration(int(int32(timestamp - buf.nextTimestamp)))
+}
+
+// resetTimerLocked schedules the user's buffer to be flushed after d unless
+// another frame arrives first.
+func (s *Segmenter) resetTimerLocked(userID string, buf *userBuffer, d time.Duration) {
 	if buf.timer != nil {
 		buf.timer.Stop()
 	}
-	buf.timer = time.AfterFunc(s.timeout, func() {
+	buf.timer = s.opts.Clock.AfterFunc(d, func() {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
@@ -82,13 +412,207 @@ func (s *Segmenter) resetTimerLocked(userID string, buf *userBuffer) {
 	})
 }
 
-func (s *Segmenter) flushLocked(userID string, buf *userBuffer) {
-	if len(buf.samples) == 0 {
+// splitLongLocked emits the head of the buffer while it exceeds MaxSegment,
+// cutting at the quietest frame near the limit so words are not split.
+func (s *Segmenter) splitLongLocked(userID string, buf *userBuffer) {
+	maxSamples := s.samples(s.opts.MaxSegment)
+	if maxSamples <= 0 {
 		return
 	}
-	cp := make([]int16, len(buf.samples))
-	copy(cp, buf.samples)
+	for len(buf.samples) >= maxSamples {
+		cut := quietestCut(buf.samples[:maxSamples], s.samples(s.opts.SplitWindow), s.opts.Channels)
+		s.emitLocked(userID, buf, buf.samples[:cut], false)
+		buf.samples = buf.samples[:copy(buf.samples, buf.samples[cut:])]
+		if buf.trailing > len(buf.samples) {
+			buf.trailing = len(buf.samples)
+		}
+		buf.utterance = 0
+		buf.snapshot = 0
+		// The rest continues from a quiet point mid-speech.
+		buf.lead = nil
+	}
+}
+
+// snapshotLocked emits an interim segment once enough new audio has arrived.
+func (s *Segmenter) snapshotLocked(userID string, buf *userBuffer) {
+	interval := s.samples(s.opts.InterimInterval)
+	if interval <= 0 || len(buf.samples)-buf.snapshot < interval {
+		return
+	}
+	s.emitLocked(userID, buf, buf.samples[:len(buf.samples)-buf.trailing], true)
+	buf.snapshot = len(buf.samples)
+}
+
+func (s *Segmenter) flushLocked(userID string, buf *userBuffer) {
+	samples := buf.samples[:len(buf.samples)-buf.trailing]
 	buf.samples = buf.samples[:0]
+	buf.speech = false
+	buf.trailing = 0
+	s.emitLocked(userID, buf, samples, false)
+	buf.utterance = 0
+	buf.snapshot = 0
+	buf.lead = nil
+}
+
+// startUtteranceLocked prepares the pre-roll when the buffer is about to
+// receive the first samples of an utterance.
+func (s *Segmenter) startUtteranceLocked(buf *userBuffer) {
+	if len(buf.samples) > 0 {
+		return
+	}
+	buf.lead = preRoll(buf.preRoll, s.samples(s.opts.PreRoll), &buf.noise)
+	buf.preRoll = buf.preRoll[:0]
+}
+
+// keepPreRollLocked remembers a dropped non-speech frame, keeping at most
+// PreRoll of the most recent audio.
+func (s *Segmenter) keepPreRollLocked(buf *userBuffer, frame []int16) {
+	limit := s.samples(s.opts.PreRoll)
+	if limit <= 0 {
+		return
+	}
+	buf.preRoll = append(buf.preRoll, frame...)
+	if over := len(buf.preRoll) - limit; over > 0 {
+		buf.preRoll = buf.preRoll[:copy(buf.preRoll, buf.preRoll[over:])]
+	}
+}
+
+// emitLocked queues a copy of samples for the consumer as part of buf's current
+// utterance, padded with its pre-roll and, for final segments, the post-roll.
+func (s *Segmenter) emitLocked(userID string, buf *userBuffer, samples []int16, interim bool) {
+	if len(samples) == 0 {
+		return
+	}
+	if buf.utterance == 0 {
+		buf.utterance = utteranceSeq.Add(1)
+	}
+	var tail int
+	if !interim {
+		tail = s.samples(s.opts.PostRoll)
+	}
+	cp := make([]int16, len(buf.lead)+len(samples)+tail)
+	copy(cp, buf.lead)
+	copy(cp[len(buf.lead):], samples)
+
+	segment := Segment{
+		GuildID:     s.guildID,
+		UserID:      userID,
+		UtteranceID: buf.utterance,
+		Interim:     interim,
+		Samples:     cp,
+		Channels:    s.opts.Channels,
+		Lead:        len(buf.lead),
+		Tail:        tail,
+	}
+	s.pending = append(s.pending, segment)
+	if !s.delivering {
+		s.delivering = true
+		go s.deliver()
+	}
+}
+
+// deliver hands pending segments to the consumer until none are left.
+func (s *Segmenter) deliver() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.pending) > 0 {
+		batch := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+		for _, segment := range batch {
+			s.consumer(segment)
+		}
+		s.mu.Lock()
+	}
+	s.delivering = false
+	s.idle.Broadcast()
+}
+
+// comfortNoiseDBFS is the comfort noise level used when no audio preceded
+// an utterance, roughly the noise floor of a quiet microphone.
+const comfortNoiseDBFS = -60
+
+// preRoll returns n samples ending with the recent audio in dropped and
+// starting with comfort noise at its RMS level.
+func preRoll(dropped []int16, n int, noise *uint32) []int16 {
+	if n <= 0 {
+		return nil
+	}
+	lead := make([]int16, n)
+	keep := min(len(dropped), n)
+	copy(lead[n-keep:], dropped[len(dropped)-keep:])
+
+	level := dbToGain(comfortNoiseDBFS) * 32768
+	if keep > 0 {
+		var sum float64
+		for _, v := range dropped[len(dropped)-keep:] {
+			sum += float64(v) * float64(v)
+		}
+		level = math.Sqrt(sum / float64(keep))
+	}
+	// Uniform noise in [-a, a] has an RMS of a/sqrt(3).
+	amplitude := level * math.Sqrt(3)
+	for i := range lead[:n-keep] {
+		// xorshift32 keeps the noise, and so replays, deterministic.
+		x := *noise
+		if x == 0 {
+			x = 2463534242
+		}
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		*noise = x
+		lead[i] = clampInt16((float64(x)/math.MaxUint32*2 - 1) * amplitude)
+	}
+	return lead
+}
+
+// quietestCut returns the index at the centre of the lowest-energy 20ms frame
+// among the frames that start within the last window samples of samples.
+func quietestCut(samples []int16, window, channels int) int {
+	step := frameSamples * channels
+	first := len(samples) - window
+	if first < 0 {
+		first = 0
+	}
+	first -= first % step
+
+	best, bestEnergy := len(samples), int64(-1)
+	for start := first; start+step <= len(samples); start += step {
+		var energy int64
+		for _, v := range samples[start : start+step] {
+			energy += int64(v) * int64(v)
+		}
+		if bestEnergy < 0 || energy < bestEnergy {
+			best, bestEnergy = start+step/2, energy
+		}
+	}
+	best -= best % channels
+	if best <= 0 {
+		return len(samples)
+	}
+	return best
+}
+
+// samples converts a duration into an interleaved sample count in the
+// segmenter's channel layout.
+func (s *Segmenter) samples(d time.Duration) int {
+	return durationSamples(d) * s.opts.Channels
+}
+
+// duration converts an interleaved sample count in the segmenter's channel
+// layout into a duration.
+func (s *Segmenter) duration(samples int) time.Duration {
+	return samplesDuration(samples / s.opts.Channels)
+}
+
+// durationSamples converts a duration into a per-channel sample count at SampleRate.
+func durationSamples(d time.Duration) int {
+	return int(d * SampleRate / time.Second)
+}
 
-	go s.consumer(s.guildID, userID, cp)
+// samplesDuration converts a per-channel sample count at SampleRate into a duration.
+func samplesDuration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / SampleRate
 }
@@ -0,0 +1,314 @@
+package audio
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+type capturedSegment struct {
+	userID    string
+	utterance uint64
+	interim   bool
+	samples   int
+}
+
+func newTestSegmenter(opts SegmenterOptions) (*Segmenter, *ManualClock, chan capturedSegment) {
+	clock := NewManualClock(time.Unix(0, 0))
+	opts.Clock = clock
+	out := make(chan capturedSegment, 16)
+	seg := NewSegmenter("guild", opts, func(segment Segment) {
+		out <- capturedSegment{
+			userID:    segment.UserID,
+			utterance: segment.UtteranceID,
+			interim:   segment.Interim,
+			samples:   len(segment.Samples),
+		}
+	})
+	return seg, clock, out
+}
+
+func expectSegment(t *testing.T, out chan capturedSegment, samples int) capturedSegment {
+	t.Helper()
+	select {
+	case got := <-out:
+		if got.samples != samples {
+			t.Fatalf("expected segment with %d samples, got %d", samples, got.samples)
+		}
+		return got
+	case <-time.After(time.Second):
+		t.Fatalf("expected segment with %d samples, got none", samples)
+	}
+	return capturedSegment{}
+}
+
+func expectNoSegment(t *testing.T, out chan capturedSegment) {
+	t.Helper()
+	select {
+	case got := <-out:
+		t.Fatalf("unexpected segment with %d samples", got.samples)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSegmenterSplitsOnTimestampGap(t *testing.T) {
+	seg, clock, out := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp})
+	frame := make([]int16, frameSamples)
+
+	var ts uint32
+	for i := 0; i < 10; i++ {
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+		clock.Advance(frameDuration)
+	}
+	// Resume after 1.2s of RTP time, even though the packet arrives immediately.
+	ts += SampleRate * 6 / 5
+	seg.AddFrame("u", ts, frame)
+	expectSegment(t, out, 10*frameSamples)
+
+	clock.Advance(time.Second)
+	expectNoSegment(t, out)
+	clock.Advance(idleTimeoutSlack)
+	expectSegment(t, out, frameSamples)
+}
+
+func TestSegmenterIgnoresArrivalJitterInTimestampMode(t *testing.T) {
+	seg, clock, out := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp})
+	frame := make([]int16, frameSamples)
+
+	seg.AddFrame("u", 0, frame)
+	// A network stall delays the next packet past the silence threshold.
+	clock.Advance(1200 * time.Millisecond)
+	seg.AddFrame("u", frameSamples, frame)
+	expectNoSegment(t, out)
+
+	seg.Stop()
+	expectSegment(t, out, 2*frameSamples)
+}
+
+func TestSegmenterKeepsLateFramesInTimestampMode(t *testing.T) {
+	seg, _, out := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByTimestamp})
+	frame := make([]int16, frameSamples)
+
+	// Live frames at 2s, then audio buffered from before the SSRC mapping.
+	live := uint32(2 * SampleRate)
+	seg.AddFrame("u", live, frame)
+	seg.AddFrame("u", live+frameSamples, frame)
+	for i := range 3 {
+		seg.AddFrame("u", uint32(i*frameSamples), frame)
+	}
+	expectNoSegment(t, out)
+
+	// The next live frame continues the utterance.
+	seg.AddFrame("u", live+2*frameSamples, frame)
+	expectNoSegment(t, out)
+
+	seg.Stop()
+	expectSegment(t, out, 6*frameSamples)
+}
+
+func TestSegmenterArrivalMode(t *testing.T) {
+	seg, clock, out := newTestSegmenter(SegmenterOptions{Silence: time.Second, Mode: SegmentByArrival})
+	frame := make([]int16, frameSamples)
+
+	seg.AddFrame("u", 0, frame)
+	clock.Advance(999 * time.Millisecond)
+	expectNoSegment(t, out)
+	clock.Advance(time.Millisecond)
+	expectSegment(t, out, frameSamples)
+}
+
+func TestSegmenterVADSplitsContinuousNoise(t *testing.T) {
+	factory, _ := ParseVAD(VADEnergy)
+	seg, clock, out := newTestSegmenter(SegmenterOptions{Silence: 500 * time.Millisecond, Mode: SegmentByTimestamp, VAD: factory})
+	rng := rand.New(rand.NewSource(1))
+
+	var ts uint32
+	add := func(frame []int16) {
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+		clock.Advance(frameDuration)
+	}
+	for i := 0; i < 50; i++ {
+		add(noiseFrame(rng, 60))
+	}
+	for i := 0; i < 25; i++ {
+		add(voicedFrame(i, 4000))
+	}
+	// The microphone stays open: low-level noise packets keep arriving.
+	for i := 0; i < 40; i++ {
+		add(noiseFrame(rng, 60))
+	}
+	expectSegment(t, out, (25+vadHangoverFrames)*frameSamples)
+}
+
+func TestSegmenterSplitsLongUtteranceAtQuietestFrame(t *testing.T) {
+	seg, _, out := newTestSegmenter(SegmenterOptions{
+		Silence:     time.Second,
+		Mode:        SegmentByTimestamp,
+		MaxSegment:  2 * time.Second,
+		SplitWindow: 500 * time.Millisecond,
+	})
+
+	const quietFrame = 88 // inside the last 500ms before the 2s (100 frame) limit
+	var ts uint32
+	for i := 0; i < 150; i++ {
+		frame := voicedFrame(i, 4000)
+		if i == quietFrame {
+			frame = make([]int16, frameSamples)
+		}
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+	}
+	expectSegment(t, out, quietFrame*frameSamples+frameSamples/2)
+	expectNoSegment(t, out)
+
+	seg.Stop()
+	expectSegment(t, out, 150*frameSamples-(quietFrame*frameSamples+frameSamples/2))
+}
+
+func TestSegmenterEmitsInterimSnapshots(t *testing.T) {
+	seg, _, out := newTestSegmenter(SegmenterOptions{
+		Silence:         time.Second,
+		Mode:            SegmentByTimestamp,
+		InterimInterval: 500 * time.Millisecond,
+	})
+	frame := make([]int16, frameSamples)
+
+	var ts uint32
+	for i := 0; i < 60; i++ {
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+	}
+	// Snapshots are delivered in emission order.
+	first, second := <-out, <-out
+	if first.samples != 25*frameSamples || second.samples != 50*frameSamples {
+		t.Fatalf("expected snapshots of 25 and 50 frames, got %d and %d samples", first.samples, second.samples)
+	}
+	if !first.interim || !second.interim {
+		t.Fatalf("expected interim snapshots, got %+v %+v", first, second)
+	}
+	expectNoSegment(t, out)
+
+	seg.Stop()
+	final := expectSegment(t, out, 60*frameSamples)
+	if final.interim {
+		t.Fatalf("expected final segment, got interim")
+	}
+	if final.utterance != first.utterance || second.utterance != first.utterance {
+		t.Fatalf("expected snapshots and final to share an utterance ID, got %d %d %d", first.utterance, second.utterance, final.utterance)
+	}
+}
+
+func TestSegmenterClosesUtteranceOnSpeakingStop(t *testing.T) {
+	seg, clock, out := newTestSegmenter(SegmenterOptions{
+		Silence:       time.Second,
+		Mode:          SegmentByTimestamp,
+		SpeakingGrace: 200 * time.Millisecond,
+	})
+	frame := make([]int16, frameSamples)
+
+	var ts uint32
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			seg.AddFrame("u", ts, frame)
+			ts += frameSamples
+			clock.Advance(frameDuration)
+		}
+	}
+	add(5)
+	// Speaking resumes within the grace period: the utterance continues.
+	seg.SetSpeaking("u", false)
+	clock.Advance(100 * time.Millisecond)
+	seg.SetSpeaking("u", true)
+	clock.Advance(150 * time.Millisecond)
+	expectNoSegment(t, out)
+
+	add(3)
+	seg.SetSpeaking("u", false)
+	// A trailing packet still arrives within the grace period.
+	add(1)
+	clock.Advance(200*time.Millisecond - frameDuration)
+	expectSegment(t, out, 9*frameSamples)
+
+	// Without speaking updates the silence timer still closes utterances.
+	add(2)
+	clock.Advance(time.Second + idleTimeoutSlack)
+	expectSegment(t, out, 2*frameSamples)
+}
+
+func TestSegmenterPadsUtterances(t *testing.T) {
+	factory, _ := ParseVAD(VADEnergy)
+	var got []Segment
+	clock := NewManualClock(time.Unix(0, 0))
+	seg := NewSegmenter("guild", SegmenterOptions{
+		Silence:  500 * time.Millisecond,
+		Mode:     SegmentByTimestamp,
+		Clock:    clock,
+		VAD:      factory,
+		PreRoll:  100 * time.Millisecond,
+		PostRoll: 60 * time.Millisecond,
+	}, func(segment Segment) {
+		got = append(got, segment)
+	})
+	rng := rand.New(rand.NewSource(1))
+
+	var (
+		ts    uint32
+		noise []int16
+	)
+	add := func(frame []int16) {
+		seg.AddFrame("u", ts, frame)
+		ts += frameSamples
+		clock.Advance(frameDuration)
+	}
+	for i := 0; i < 20; i++ {
+		frame := noiseFrame(rng, 60)
+		noise = append(noise, frame...)
+		add(frame)
+	}
+	for i := 0; i < 25; i++ {
+		add(voicedFrame(i, 4000))
+	}
+	seg.Stop()
+
+	if len(got) != 1 {
+		t.Fatalf("expected one segment, got %d", len(got))
+	}
+	s := got[0]
+	lead, tail := durationSamples(100*time.Millisecond), durationSamples(60*time.Millisecond)
+	if s.Lead != lead || s.Tail != tail || len(s.Speech()) != 25*frameSamples {
+		t.Fatalf("unexpected padding lead=%d tail=%d speech=%d", s.Lead, s.Tail, len(s.Speech()))
+	}
+	// The pre-roll is the noise the VAD dropped right before the utterance.
+	if !slices.Equal(s.Samples[:lead], noise[len(noise)-lead:]) {
+		t.Fatalf("pre-roll does not match the dropped frames")
+	}
+	for _, v := range s.Samples[len(s.Samples)-tail:] {
+		if v != 0 {
+			t.Fatalf("post-roll is not silent")
+		}
+	}
+}
+
+func TestSegmenterPreRollComfortNoise(t *testing.T) {
+	var got []Segment
+	seg := NewSegmenter("guild", SegmenterOptions{
+		Silence: time.Second,
+		Clock:   NewManualClock(time.Unix(0, 0)),
+		PreRoll: 200 * time.Millisecond,
+	}, func(segment Segment) {
+		got = append(got, segment)
+	})
+	seg.AddFrame("u", 0, voicedFrame(0, 4000))
+	seg.Stop()
+
+	if len(got) != 1 || got[0].Lead != durationSamples(200*time.Millisecond) || got[0].Tail != 0 {
+		t.Fatalf("unexpected segments %+v", got)
+	}
+	// Nothing preceded the first packet, so the pre-roll is comfort noise.
+	if level := frameDBFS(got[0].Samples[:got[0].Lead]); level < comfortNoiseDBFS-3 || level > comfortNoiseDBFS+3 {
+		t.Fatalf("expected comfort noise around %d dBFS, got %.1f", comfortNoiseDBFS, level)
+	}
+}
@@ -0,0 +1,135 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+// VoiceActivityDetector classifies 20ms mono PCM frames at SampleRate as speech or not.
+// Implementations keep per-stream state and are not safe for concurrent use.
+type VoiceActivityDetector interface {
+	IsSpeech(frame []int16) bool
+}
+
+// VADFactory creates a detector for a single user's stream.
+type VADFactory func() VoiceActivityDetector
+
+// VAD names accepted by ParseVAD.
+const (
+	VADOff    = "off"
+	VADEnergy = "energy"
+	VADGMM    = "gmm"
+)
+
+// ParseVAD returns the detector factory registered under name. The "off"
+// detector yields a nil factory, which disables voice activity detection.
+func ParseVAD(name string) (VADFactory, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case VADOff, "none", "":
+		return nil, true
+	case VADEnergy:
+		return func() VoiceActivityDetector { return NewEnergyVAD() }, true
+	case VADGMM, "webrtc":
+		return func() VoiceActivityDetector { return NewGMMVAD() }, true
+	}
+	return nil, false
+}
+
+const (
+	energyMarginDB     = 9.0
+	energyMinSpeechDB  = -55.0
+	energyMaxZCR       = 0.3
+	energyFloorRiseDB  = 0.02 // per frame, ~1dB/s
+	energyInitialFloor = -70.0
+	energyFloorWindow  = 50 // frames, 1s
+	vadHangoverFrames  = 8
+)
+
+// EnergyVAD detects speech from frame energy relative to an adaptive noise
+// floor, rejecting broadband noise by its zero-crossing rate. The floor
+// drops to quieter frames at once and otherwise rises slowly, but never
+// stays below the quietest frame of the last second: speech pauses within
+// that time, so steady noise such as hum or a fan stops counting as speech
+// after about a second.
+type EnergyVAD struct {
+	floor    float64
+	recent   [energyFloorWindow]float64 // energy of the most recent frames
+	frames   int
+	hangover hangover
+}
+
+// NewEnergyVAD returns an EnergyVAD with its noise floor at -70 dBFS.
+func NewEnergyVAD() *EnergyVAD {
+	return &EnergyVAD{floor: energyInitialFloor}
+}
+
+// IsSpeech implements VoiceActivityDetector.
+func (v *EnergyVAD) IsSpeech(frame []int16) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	energy := frameDBFS(frame)
+	zcr := zeroCrossingRate(frame)
+
+	if energy < v.floor {
+		v.floor = energy
+	} else {
+		v.floor += energyFloorRiseDB
+	}
+	v.recent[v.frames%energyFloorWindow] = energy
+	v.frames++
+	if v.frames >= energyFloorWindow {
+		v.floor = max(v.floor, slices.Min(v.recent[:]))
+	}
+
+	speech := energy > v.floor+energyMarginDB && energy > energyMinSpeechDB && zcr < energyMaxZCR
+	return v.hangover.apply(speech)
+}
+
+// hangover keeps a detector reporting speech for vadHangoverFrames after
+// the last speech frame, so word endings and short pauses are not cut.
+type hangover int
+
+func (h *hangover) apply(speech bool) bool {
+	if speech {
+		*h = vadHangoverFrames
+		return true
+	}
+	if *h > 0 {
+		*h--
+		return true
+	}
+	return false
+}
+
+// frameDBFS returns the RMS level of frame in dBFS, floored at -120.
+func frameDBFS(frame []int16) float64 {
+	if len(frame) == 0 {
+		return -120
+	}
+	var sum float64
+	for _, s := range frame {
+		f := float64(s) / 32768
+		sum += f * f
+	}
+	mean := sum / float64(len(frame))
+	if mean <= 1e-12 {
+		return -120
+	}
+	return 10 * math.Log10(mean)
+}
+
+// zeroCrossingRate returns the fraction of adjacent samples that change sign.
+func zeroCrossingRate(frame []int16) float64 {
+	if len(frame) < 2 {
+		return 0
+	}
+	var crossings int
+	for i := 1; i < len(frame); i++ {
+		if (frame[i-1] >= 0) != (frame[i] >= 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / float64(len(frame)-1)
+}
@@ -0,0 +1,171 @@
+package audio
+
+import "math"
+
+// gmmBands are the sub-band edges in Hz used as features, following WebRTC's VAD.
+var gmmBands = [...][2]float64{
+	{80, 250}, {250, 500}, {500, 1000}, {1000, 2000}, {2000, 3000}, {3000, 4000},
+}
+
+// gmmBandWeights weight each band's log-likelihood ratio in the global decision.
+var gmmBandWeights = [len(gmmBands)]float64{6, 8, 10, 12, 14, 16}
+
+const (
+	gmmComponents          = 2
+	gmmGlobalThreshold     = 1.0
+	gmmIndividualThreshold = 5.0
+	gmmNoiseRate           = 0.05
+	gmmSpeechRate          = 0.01
+	gmmMinimumRate         = 0.05
+	gmmMinimumRiseDB       = 0.05
+	gmmMinSeparationDB     = 6.0
+	gmmMinStdDB            = 2.0
+	gmmMaxStdDB            = 20.0
+	gmmFeatureFloorDB      = -100.0
+)
+
+type gaussian struct {
+	weight float64
+	mean   float64
+	std    float64
+}
+
+func (g gaussian) density(x float64) float64 {
+	z := (x - g.mean) / g.std
+	return g.weight * math.Exp(-0.5*z*z) / (g.std * math.Sqrt(2*math.Pi))
+}
+
+type bandModel struct {
+	noise   [gmmComponents]gaussian
+	speech  [gmmComponents]gaussian
+	minimum float64
+	primed  bool
+}
+
+// GMMVAD is a WebRTC-style detector. Each sub-band's log energy is scored
+// against two-component Gaussian mixtures for noise and speech, and the
+// weighted log-likelihood ratios decide the frame. The models adapt online.
+type GMMVAD struct {
+	bands    [len(gmmBands)]bandModel
+	hangover hangover
+}
+
+// NewGMMVAD returns a GMMVAD with generic initial noise and speech models.
+func NewGMMVAD() *GMMVAD {
+	v := &GMMVAD{}
+	for i := range v.bands {
+		v.bands[i] = bandModel{
+			noise: [gmmComponents]gaussian{
+				{weight: 0.5, mean: -45, std: 8},
+				{weight: 0.5, mean: -38, std: 8},
+			},
+			speech: [gmmComponents]gaussian{
+				{weight: 0.5, mean: -10, std: 12},
+				{weight: 0.5, mean: 10, std: 12},
+			},
+		}
+	}
+	return v
+}
+
+// IsSpeech implements VoiceActivityDetector.
+func (v *GMMVAD) IsSpeech(frame []int16) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	features := gmmFeatures(frame)
+
+	var total, weightSum float64
+	individual := false
+	for i, x := range features {
+		band := &v.bands[i]
+		llr := math.Log(mixtureDensity(band.speech[:], x)+1e-300) - math.Log(mixtureDensity(band.noise[:], x)+1e-300)
+		total += gmmBandWeights[i] * llr
+		weightSum += gmmBandWeights[i]
+		if llr > gmmIndividualThreshold {
+			individual = true
+		}
+	}
+	speech := total/weightSum > gmmGlobalThreshold || individual
+
+	for i, x := range features {
+		v.bands[i].adapt(x, speech)
+	}
+	return v.hangover.apply(speech)
+}
+
+func (b *bandModel) adapt(x float64, speech bool) {
+	if !b.primed || x < b.minimum {
+		b.minimum = x
+		b.primed = true
+	} else {
+		b.minimum += gmmMinimumRiseDB
+	}
+
+	if speech {
+		updateMixture(b.speech[:], x, gmmSpeechRate)
+	} else {
+		updateMixture(b.noise[:], x, gmmNoiseRate)
+	}
+
+	// Track the long-term minimum so the noise model follows a rising noise floor.
+	for j := range b.noise {
+		b.noise[j].mean += gmmMinimumRate * (b.minimum + float64(j)*gmmMinSeparationDB/2 - b.noise[j].mean)
+	}
+
+	noiseTop := math.Max(b.noise[0].mean, b.noise[1].mean)
+	for j := range b.speech {
+		if b.speech[j].mean < noiseTop+gmmMinSeparationDB {
+			b.speech[j].mean = noiseTop + gmmMinSeparationDB
+		}
+	}
+}
+
+func mixtureDensity(mix []gaussian, x float64) float64 {
+	var p float64
+	for _, g := range mix {
+		p += g.density(x)
+	}
+	return p
+}
+
+func updateMixture(mix []gaussian, x, rate float64) {
+	total := mixtureDensity(mix, x)
+	if total <= 0 {
+		return
+	}
+	for j := range mix {
+		resp := mix[j].density(x) / total
+		g := &mix[j]
+		diff := x - g.mean
+		g.mean += rate * resp * diff
+		variance := g.std*g.std + rate*resp*(diff*diff-g.std*g.std)
+		g.std = math.Min(gmmMaxStdDB, math.Max(gmmMinStdDB, math.Sqrt(math.Max(variance, 0))))
+	}
+}
+
+// gmmFeatures returns the mean power per FFT bin of each band in dB.
+func gmmFeatures(frame []int16) [len(gmmBands)]float64 {
+	spectrum := powerSpectrum(frame)
+	binHz := float64(SampleRate) / float64(2*(len(spectrum)-1))
+	var features [len(gmmBands)]float64
+	for i, band := range gmmBands {
+		lo := int(math.Ceil(band[0] / binHz))
+		hi := int(math.Floor(band[1] / binHz))
+		if hi >= len(spectrum) {
+			hi = len(spectrum) - 1
+		}
+		var sum float64
+		count := 0
+		for k := lo; k <= hi; k++ {
+			sum += spectrum[k]
+			count++
+		}
+		if count == 0 || sum <= 0 {
+			features[i] = gmmFeatureFloorDB
+			continue
+		}
+		features[i] = math.Max(gmmFeatureFloorDB, 10*math.Log10(sum/float64(count)))
+	}
+	return features
+}
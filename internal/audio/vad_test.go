@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// noiseFrame returns white noise with the given peak amplitude.
+func noiseFrame(rng *rand.Rand, amplitude float64) []int16 {
+	frame := make([]int16, frameSamples)
+	for i := range frame {
+		frame[i] = int16((rng.Float64()*2 - 1) * amplitude)
+	}
+	return frame
+}
+
+// voicedFrame returns a harmonic-rich tone resembling a voiced vowel.
+func voicedFrame(index int, amplitude float64) []int16 {
+	frame := make([]int16, frameSamples)
+	for i := range frame {
+		t := float64(index*frameSamples+i) / SampleRate
+		var v float64
+		for h := 1; h <= 12; h++ {
+			v += math.Sin(2*math.Pi*150*float64(h)*t) / float64(h)
+		}
+		frame[i] = int16(amplitude * v / 2)
+	}
+	return frame
+}
+
+func countSpeech(vad VoiceActivityDetector, frames [][]int16) int {
+	n := 0
+	for _, f := range frames {
+		if vad.IsSpeech(f) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestVoiceActivityDetectors(t *testing.T) {
+	for _, name := range []string{VADEnergy, VADGMM} {
+		factory, ok := ParseVAD(name)
+		if !ok || factory == nil {
+			t.Fatalf("%s: detector not registered", name)
+		}
+		vad := factory()
+		rng := rand.New(rand.NewSource(1))
+
+		var noise [][]int16
+		for i := 0; i < 100; i++ {
+			noise = append(noise, noiseFrame(rng, 60))
+		}
+		if n := countSpeech(vad, noise); n > 5 {
+			t.Errorf("%s: %d of %d background noise frames classified as speech", name, n, len(noise))
+		}
+
+		var voiced [][]int16
+		for i := 0; i < 50; i++ {
+			frame := voicedFrame(i, 3000)
+			for n, v := range noiseFrame(rng, 60) {
+				frame[n] += v
+			}
+			voiced = append(voiced, frame)
+		}
+		if n := countSpeech(vad, voiced); n < 45 {
+			t.Errorf("%s: only %d of %d voiced frames classified as speech", name, n, len(voiced))
+		}
+
+		var trailing [][]int16
+		for i := 0; i < 50; i++ {
+			trailing = append(trailing, noiseFrame(rng, 60))
+		}
+		if n := countSpeech(vad, trailing); n > vadHangoverFrames+5 {
+			t.Errorf("%s: %d trailing noise frames classified as speech", name, n)
+		}
+	}
+}
+
+func TestEnergyVADAdaptsToSteadyNoise(t *testing.T) {
+	// A 100Hz hum at -40 dBFS: loud and with few zero crossings, like a fan
+	// or mains hum picked up by an open microphone.
+	vad := NewEnergyVAD()
+	var hum [][]int16
+	for i := 0; i < 200; i++ {
+		frame := make([]int16, frameSamples)
+		for n := range frame {
+			frame[n] = int16(460 * math.Sin(2*math.Pi*100*float64(i*frameSamples+n)/SampleRate))
+		}
+		hum = append(hum, frame)
+	}
+	if n := countSpeech(vad, hum); n > energyFloorWindow+vadHangoverFrames {
+		t.Errorf("%d of %d hum frames classified as speech", n, len(hum))
+	}
+	if !vad.IsSpeech(voicedFrame(0, 3000)) {
+		t.Errorf("speech over the hum not detected")
+	}
+}
@@ -1,76 +1,299 @@
 package audio
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 )
 
-// WritePCM16ToWAV writes the provided PCM samples into a signed 16-bit mono/stereo WAV file.
-func WritePCM16ToWAV(path string, samples []int16, sampleRate, channels int) error {
+const (
+	wavHeaderSize = 44
+	wavFormatPCM  = 1
+	// wavFormatExtensible carries the real format code in a sub-format GUID.
+	wavFormatExtensible = 0xfffe
+	// wavUnknownSize is written as the RIFF and data sizes when a stream's
+	// length is unknown and its header cannot be patched afterwards.
+	wavUnknownSize = 0xffffffff
+	// wavChunkSamples is how many samples are converted per write.
+	wavChunkSamples = 4096
+)
+
+// WAV is decoded signed 16-bit PCM audio.
+type WAV struct {
+	SampleRate int
+	Channels   int
+	// Samples are interleaved when Channels > 1.
+	Samples []int16
+}
+
+// wavHeader returns a canonical 44-byte PCM16 header for dataLen bytes of samples.
+func wavHeader(sampleRate, channels int, dataLen uint32) []byte {
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:], "RIFF")
+	riffLen := dataLen
+	if dataLen != wavUnknownSize {
+		riffLen = wavHeaderSize - 8 + dataLen
+	}
+	binary.LittleEndian.PutUint32(h[4:], riffLen)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(h[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(h[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataLen)
+	return h
+}
+
+func validateFormat(sampleRate, channels int) error {
 	if channels <= 0 {
 		return fmt.Errorf("channels must be positive")
 	}
 	if sampleRate <= 0 {
 		return fmt.Errorf("sample rate must be positive")
 	}
+	return nil
+}
 
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create wav file: %w", err)
+// EncodeWAV writes samples to w as a complete PCM16 WAV stream.
+func EncodeWAV(w io.Writer, samples []int16, sampleRate, channels int) error {
+	if err := validateFormat(sampleRate, channels); err != nil {
+		return err
 	}
-	defer file.Close()
+	if 2*uint64(len(samples)) > wavUnknownSize-wavHeaderSize {
+		return fmt.Errorf("wav data exceeds 4GiB")
+	}
+	if _, err := w.Write(wavHeader(sampleRate, channels, uint32(2*len(samples)))); err != nil {
+		return fmt.Errorf("write wav header: %w", err)
+	}
+	return writePCM16(w, samples)
+}
 
-	byteRate := sampleRate * channels * 2
-	blockAlign := channels * 2
-	dataLen := len(samples) * 2
-	chunkSize := 36 + dataLen
+// writePCM16 writes little-endian samples in bounded chunks.
+func writePCM16(w io.Writer, samples []int16) error {
+	buf := make([]byte, 2*min(len(samples), wavChunkSamples))
+	for len(samples) > 0 {
+		n := min(len(samples), wavChunkSamples)
+		for i, v := range samples[:n] {
+			binary.LittleEndian.PutUint16(buf[2*i:], uint16(v))
+		}
+		if _, err := w.Write(buf[:2*n]); err != nil {
+			return fmt.Errorf("write wav samples: %w", err)
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
 
-	// RIFF header
-	if _, err := file.Write([]byte("RIFF")); err != nil {
-		return fmt.Errorf("write riff header: %w", err)
+// WAVWriter streams PCM16 samples of unknown total length as WAV. If the
+// destination is an io.WriteSeeker, Close patches the sizes in the header;
+// otherwise the sizes stay at the 0xFFFFFFFF "unknown" marker that streaming
+// readers, including ReadWAV, accept.
+type WAVWriter struct {
+	w       io.Writer
+	dataLen uint64
+	err     error
+}
+
+// NewWAVWriter writes the WAV header to w and returns a writer for the samples.
+func NewWAVWriter(w io.Writer, sampleRate, channels int) (*WAVWriter, error) {
+	if err := validateFormat(sampleRate, channels); err != nil {
+		return nil, err
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint32(chunkSize)); err != nil {
-		return fmt.Errorf("write chunk size: %w", err)
+	if _, err := w.Write(wavHeader(sampleRate, channels, wavUnknownSize)); err != nil {
+		return nil, fmt.Errorf("write wav header: %w", err)
 	}
-	if _, err := file.Write([]byte("WAVEfmt ")); err != nil {
-		return fmt.Errorf("write wave fmt: %w", err)
+	return &WAVWriter{w: w}, nil
+}
+
+// WriteSamples appends interleaved samples.
+func (ww *WAVWriter) WriteSamples(samples []int16) error {
+	if ww.err != nil {
+		return ww.err
 	}
+	if ww.dataLen+2*uint64(len(samples)) > wavUnknownSize-wavHeaderSize {
+		ww.err = fmt.Errorf("wav data exceeds 4GiB")
+		return ww.err
+	}
+	if err := writePCM16(ww.w, samples); err != nil {
+		ww.err = err
+		return err
+	}
+	ww.dataLen += 2 * uint64(len(samples))
+	return nil
+}
 
-	if err := binary.Write(file, binary.LittleEndian, uint32(16)); err != nil {
-		return fmt.Errorf("write subchunk size: %w", err)
+// Close finalises the header sizes when the destination is seekable. It does
+// not close the underlying writer.
+func (ww *WAVWriter) Close() error {
+	if ww.err != nil {
+		return ww.err
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint16(1)); err != nil { // PCM format
-		return fmt.Errorf("write audio format: %w", err)
+	seeker, ok := ww.w.(io.WriteSeeker)
+	if !ok {
+		return nil
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint16(channels)); err != nil {
-		return fmt.Errorf("write channels: %w", err)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(wavHeaderSize-8+ww.dataLen))
+	if err := writeAt(seeker, 4, size[:]); err != nil {
+		return err
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint32(sampleRate)); err != nil {
-		return fmt.Errorf("write sample rate: %w", err)
+	binary.LittleEndian.PutUint32(size[:], uint32(ww.dataLen))
+	if err := writeAt(seeker, 40, size[:]); err != nil {
+		return err
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint32(byteRate)); err != nil {
-		return fmt.Errorf("write byte rate: %w", err)
+	if _, err := seeker.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("seek wav end: %w", err)
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint16(blockAlign)); err != nil {
-		return fmt.Errorf("write block align: %w", err)
+	return nil
+}
+
+func writeAt(ws io.WriteSeeker, offset int64, p []byte) error {
+	if _, err := ws.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek wav header: %w", err)
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint16(16)); err != nil { // bits per sample
-		return fmt.Errorf("write bits per sample: %w", err)
+	if _, err := ws.Write(p); err != nil {
+		return fmt.Errorf("patch wav header: %w", err)
 	}
+	return nil
+}
 
-	if _, err := file.Write([]byte("data")); err != nil {
-		return fmt.Errorf("write data header: %w", err)
+// WritePCM16ToWAV writes the provided PCM samples into a signed 16-bit mono/stereo WAV file.
+func WritePCM16ToWAV(path string, samples []int16, sampleRate, channels int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create wav file: %w", err)
+	}
+	bw := bufio.NewWriter(file)
+	if err := EncodeWAV(bw, samples, sampleRate, channels); err != nil {
+		file.Close()
+		return err
 	}
-	if err := binary.Write(file, binary.LittleEndian, uint32(dataLen)); err != nil {
-		return fmt.Errorf("write data length: %w", err)
+	if err := bw.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("flush wav file: %w", err)
 	}
+	return file.Close()
+}
 
-	for _, sample := range samples {
-		if err := binary.Write(file, binary.LittleEndian, sample); err != nil {
-			return fmt.Errorf("write sample: %w", err)
+// ReadWAV parses a RIFF/WAVE stream of 16-bit PCM. Unknown chunks are skipped,
+// and a data chunk of unknown (0xFFFFFFFF) or overstated size is read to EOF.
+func ReadWAV(r io.Reader) (WAV, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return WAV{}, fmt.Errorf("read riff header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return WAV{}, fmt.Errorf("not a RIFF/WAVE stream")
+	}
+
+	var wav WAV
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return WAV{}, fmt.Errorf("missing data chunk")
+			}
+			return WAV{}, fmt.Errorf("read chunk header: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if err := readWAVFormat(r, size, &wav); err != nil {
+				return WAV{}, err
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return WAV{}, fmt.Errorf("data chunk before fmt chunk")
+			}
+			samples, err := readWAVData(r, size, wav.Channels)
+			if err != nil {
+				return WAV{}, err
+			}
+			wav.Samples = samples
+			return wav, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size&1)); err != nil {
+				return WAV{}, fmt.Errorf("skip %q chunk: %w", id, err)
+			}
 		}
 	}
+}
+
+// ReadWAVFile reads a WAV file from disk.
+func ReadWAVFile(path string) (WAV, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WAV{}, fmt.Errorf("open wav file: %w", err)
+	}
+	defer file.Close()
+	return ReadWAV(bufio.NewReader(file))
+}
+
+func readWAVFormat(r io.Reader, size uint32, wav *WAV) error {
+	if size < 16 || size > 1024 {
+		return fmt.Errorf("invalid fmt chunk size %d", size)
+	}
+	body := make([]byte, size+size&1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return fmt.Errorf("read fmt chunk: %w", err)
+	}
+	format := binary.LittleEndian.Uint16(body[0:])
+	channels := int(binary.LittleEndian.Uint16(body[2:]))
+	sampleRate := int(binary.LittleEndian.Uint32(body[4:]))
+	blockAlign := int(binary.LittleEndian.Uint16(body[12:]))
+	bits := binary.LittleEndian.Uint16(body[14:])
 
+	if format == wavFormatExtensible {
+		if size < 40 {
+			return fmt.Errorf("truncated WAVE_FORMAT_EXTENSIBLE fmt chunk")
+		}
+		// The sub-format GUID starts with the real format code.
+		format = binary.LittleEndian.Uint16(body[24:])
+	}
+	if format != wavFormatPCM {
+		return fmt.Errorf("unsupported wav format %#x, only PCM is supported", format)
+	}
+	if bits != 16 {
+		return fmt.Errorf("unsupported wav bit depth %d, only 16-bit is supported", bits)
+	}
+	if err := validateFormat(sampleRate, channels); err != nil {
+		return fmt.Errorf("invalid wav: %w", err)
+	}
+	if blockAlign != 2*channels {
+		return fmt.Errorf("invalid wav block align %d for %d channels", blockAlign, channels)
+	}
+	wav.SampleRate = sampleRate
+	wav.Channels = channels
 	return nil
 }
+
+func readWAVData(r io.Reader, size uint32, channels int) ([]int16, error) {
+	var data []byte
+	var err error
+	if size == wavUnknownSize {
+		data, err = io.ReadAll(r)
+	} else {
+		data, err = io.ReadAll(io.LimitReader(r, int64(size)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read data chunk: %w", err)
+	}
+	// Drop a trailing partial frame left by a truncated stream.
+	data = data[:len(data)/(2*channels)*(2*channels)]
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return samples, nil
+}
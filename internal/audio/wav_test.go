@@ -0,0 +1,159 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncodeWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, 32767, -32768, 1234, -4321, 7}
+	var buf bytes.Buffer
+	if err := EncodeWAV(&buf, samples, 16000, 2); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != wavHeaderSize+2*len(samples) {
+		t.Fatalf("encoded %d bytes, want %d", buf.Len(), wavHeaderSize+2*len(samples))
+	}
+	wav, err := ReadWAV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wav.SampleRate != 16000 || wav.Channels != 2 || !slices.Equal(wav.Samples, samples) {
+		t.Fatalf("round trip mismatch: %+v", wav)
+	}
+}
+
+func TestWAVWriterPatchesSeekableHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.wav")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ww, err := NewWAVWriter(file, SampleRate, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := ww.WriteSamples(voicedFrame(i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(data[40:]); got != 3*frameSamples*2 {
+		t.Fatalf("data size %d, want %d", got, 3*frameSamples*2)
+	}
+	if got := binary.LittleEndian.Uint32(data[4:]); int(got) != len(data)-8 {
+		t.Fatalf("riff size %d, want %d", got, len(data)-8)
+	}
+	wav, err := ReadWAVFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wav.Samples) != 3*frameSamples {
+		t.Fatalf("read %d samples, want %d", len(wav.Samples), 3*frameSamples)
+	}
+}
+
+func TestWAVWriterStreamsUnknownLength(t *testing.T) {
+	var buf bytes.Buffer
+	ww, err := NewWAVWriter(&buf, 16000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ww.WriteSamples([]int16{1, 2, 3})
+	ww.WriteSamples([]int16{4, 5})
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(buf.Bytes()[40:]); got != wavUnknownSize {
+		t.Fatalf("expected unknown data size marker, got %d", got)
+	}
+	wav, err := ReadWAV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(wav.Samples, []int16{1, 2, 3, 4, 5}) {
+		t.Fatalf("unexpected samples %v", wav.Samples)
+	}
+}
+
+// chunk builds a RIFF chunk, padding odd-sized bodies.
+func chunk(id string, body []byte) []byte {
+	out := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	out = append(out, body...)
+	if len(body)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func fmtBody(format uint16, channels, rate int, bits uint16, extra []byte) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, format)
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate*channels*int(bits)/8))
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels*int(bits)/8))
+	b = binary.LittleEndian.AppendUint16(b, bits)
+	return append(b, extra...)
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestReadWAVSkipsChunksAndAcceptsExtensible(t *testing.T) {
+	extensible := binary.LittleEndian.AppendUint16(nil, 22)       // cbSize
+	extensible = binary.LittleEndian.AppendUint16(extensible, 16) // valid bits
+	extensible = binary.LittleEndian.AppendUint32(extensible, 4)  // channel mask
+	extensible = append(extensible, 1, 0, 0, 0, 0, 0, 0x10, 0, 0x80, 0, 0, 0xaa, 0, 0x38, 0x9b, 0x71)
+	data := riff(
+		chunk("LIST", []byte("odd")),
+		chunk("fmt ", fmtBody(wavFormatExtensible, 1, 8000, 16, extensible)),
+		chunk("data", []byte{1, 0, 0xff, 0xff}),
+	)
+	wav, err := ReadWAV(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wav.SampleRate != 8000 || !slices.Equal(wav.Samples, []int16{1, -1}) {
+		t.Fatalf("unexpected wav %+v", wav)
+	}
+}
+
+func TestReadWAVRejectsInvalidStreams(t *testing.T) {
+	cases := map[string]struct {
+		data []byte
+		want string
+	}{
+		"not riff":      {[]byte("RIFX\x00\x00\x00\x00WAVE"), "not a RIFF/WAVE"},
+		"float":         {riff(chunk("fmt ", fmtBody(3, 1, 8000, 32, nil)), chunk("data", nil)), "only PCM"},
+		"8-bit":         {riff(chunk("fmt ", fmtBody(wavFormatPCM, 1, 8000, 8, nil)), chunk("data", nil)), "only 16-bit"},
+		"no channels":   {riff(chunk("fmt ", fmtBody(wavFormatPCM, 0, 8000, 16, nil)), chunk("data", nil)), "channels"},
+		"data first":    {riff(chunk("data", []byte{0, 0})), "before fmt"},
+		"no data":       {riff(chunk("fmt ", fmtBody(wavFormatPCM, 1, 8000, 16, nil))), "missing data"},
+		"truncated fmt": {riff(chunk("fmt ", []byte{1, 0})), "fmt chunk size"},
+	}
+	for name, tc := range cases {
+		_, err := ReadWAV(bytes.NewReader(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one containing %q", name, err, tc.want)
+		}
+	}
+}
@@ -3,15 +3,119 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+	"github.com/pikachu0310/whisper-discord-bot/internal/stt"
 )
 
-const DefaultFWSBaseURL = "http://localhost:8000"
+const (
+	DefaultFWSBaseURL          = "http://localhost:8000"
+	DefaultSTTBackend          = "faster-whisper"
+	DefaultWhisperCpp          = "http://localhost:8080"
+	DefaultVoskURL             = "ws://localhost:2700"
+	DefaultJitterDepth         = audio.DefaultJitterDepth
+	DefaultLayout              = "mono"
+	DefaultSegmentMode         = "timestamp"
+	DefaultVADMode             = "energy"
+	DefaultFilters             = "off"
+	DefaultMaxSegment          = 30 * time.Second
+	DefaultSplitWindow         = 5 * time.Second
+	DefaultInterim             = 2 * time.Second
+	DefaultSpeaking            = time.Duration(0)
+	DefaultPreRoll             = 200 * time.Millisecond
+	DefaultPostRoll            = 200 * time.Millisecond
+	DefaultMinDuration         = audio.DefaultMinSegmentDuration
+	DefaultMinRMSDBFS          = audio.DefaultMinRMSDBFS
+	DefaultMinVoiced           = audio.DefaultMinVoicedFraction
+	DefaultMaxFlatness         = audio.DefaultMaxSpectralFlatness
+	DefaultMaxPARDB            = audio.DefaultMaxPeakToAverageDB
+	DefaultUploadRate          = 16000
+	DefaultUploadFormat        = string(audio.FormatWAV)
+	DefaultRecordDir           = "recordings"
+	DefaultMixFormat           = "ogg"
+	DefaultWorkers             = 2
+	DefaultQueueDepth          = 16
+	DefaultQueuePolicy         = "merge"
+	DefaultLanguage            = "ja"
+	DefaultPinAfter            = 3
+	DefaultBlocklist           = "default"
+	DefaultMaxRepeats          = stt.DefaultMaxRepeats
+	DefaultMaxCompressionRatio = stt.DefaultMaxCompressionRatio
+	DefaultMaxNoSpeechProb     = stt.DefaultMaxNoSpeechProb
+	DefaultMinAvgLogprob       = stt.DefaultMinAvgLogprob
+)
 
 // Config represents runtime configuration from environment variables.
 type Config struct {
 	DiscordToken        string
 	TranscriptChannelID string
 	FWSBaseURL          string
+	// STTBackend selects the speech-to-text engine: "faster-whisper", "whisper.cpp" or "vosk".
+	STTBackend string
+	// STTURL is the engine's address. Defaults to FWSBaseURL for faster-whisper
+	// and to the engine's standard port otherwise.
+	STTURL string
+	// JitterDepth is the per-SSRC jitter buffer playout delay in 20ms frames.
+	JitterDepth int
+	// ChannelLayout selects how Discord's stereo Opus is decoded: "mono" (downmix), "stereo", "left" or "right".
+	ChannelLayout string
+	// SegmentMode selects utterance boundary detection: "timestamp" (RTP) or "arrival".
+	SegmentMode string
+	// VADMode is the default voice activity detector for guilds: "off", "energy" or "gmm".
+	VADMode string
+	// AudioFilters is the default preprocessing filter chain for guilds, e.g. "highpass:80,notch:50,agc" or "off".
+	AudioFilters string
+	// MaxSegment caps a single utterance; longer speech is split at the quietest point.
+	MaxSegment time.Duration
+	// SplitWindow is how far before MaxSegment the split point is searched.
+	SplitWindow time.Duration
+	// InterimInterval is how much new audio triggers a provisional transcript of an in-progress utterance.
+	InterimInterval time.Duration
+	// SpeakingGrace is how long after Discord's speaking flag drops an utterance is closed.
+	// Zero, the default, ignores the hint and leaves segmentation to the silence threshold.
+	SpeakingGrace time.Duration
+	// PreRoll is audio prepended to each utterance; PostRoll is silence appended to each final segment.
+	PreRoll  time.Duration
+	PostRoll time.Duration
+	// UploadSampleRate is the sample rate segments are resampled to before upload.
+	UploadSampleRate int
+	// UploadFormat is the segment file format sent to Whisper: "wav", "ogg" (Opus) or "flac".
+	UploadFormat string
+	// RecordingDir is where per-session recordings are written for guilds with recording enabled.
+	RecordingDir string
+	// RecordingMixFormat is the format of the mixed session recording: "wav", "ogg" (Opus) or "off".
+	RecordingMixFormat string
+	// SegmentScore holds the thresholds segments must pass to be transcribed.
+	SegmentScore audio.ScoreThresholds
+	// TranscribeWorkers is how many segments are transcribed concurrently.
+	TranscribeWorkers int
+	// QueueDepth is how many segments may wait for a transcription worker.
+	QueueDepth int
+	// QueuePolicy applies when the queue is full: "drop-oldest", "merge" or "block".
+	QueuePolicy string
+	// Default transcription options for guilds: the model name (empty for the server's
+	// default), language code or "auto", initial prompt, sampling temperature (nil for
+	// the server's default), and beam search width and candidate count (zero for the
+	// server's default).
+	TranscribeModel       string
+	TranscribeLanguage    string
+	TranscribePrompt      string
+	TranscribeTemperature *float64
+	TranscribeBeamSize    int
+	TranscribeBestOf      int
+	// LanguagePinAfter is how many agreeing confident detections pin a user's language
+	// in guilds transcribing with language "auto"; zero never pins.
+	LanguagePinAfter int
+	// HallucinationBlocklist is a comma-separated list of phrases dropped from transcripts;
+	// "default" expands to the built-in list and "off" disables it.
+	HallucinationBlocklist string
+	// Hallucination thresholds: transcript segments beyond them are dropped.
+	HallucinationMaxRepeats     int
+	HallucinationMaxCompression float64
+	HallucinationMaxNoSpeech    float64
+	HallucinationMinLogprob     float64
 }
 
 // Load reads configuration from environment variables and validates it.
@@ -20,11 +124,128 @@ func Load() (Config, error) {
 		DiscordToken:        os.Getenv("DISCORD_TOKEN"),
 		TranscriptChannelID: os.Getenv("TRANSCRIPT_CHANNEL_ID"),
 		FWSBaseURL:          os.Getenv("FWS_BASE_URL"),
+		STTBackend:          os.Getenv("STT_BACKEND"),
+		STTURL:              os.Getenv("STT_URL"),
+		ChannelLayout:       os.Getenv("CHANNEL_LAYOUT"),
+		SegmentMode:         os.Getenv("SEGMENT_MODE"),
+		VADMode:             os.Getenv("VAD_MODE"),
+		AudioFilters:        os.Getenv("AUDIO_FILTERS"),
+		UploadFormat:        os.Getenv("UPLOAD_FORMAT"),
+		RecordingDir:        os.Getenv("RECORDING_DIR"),
+		RecordingMixFormat:  os.Getenv("RECORDING_MIX_FORMAT"),
+		QueuePolicy:         os.Getenv("TRANSCRIBE_QUEUE_POLICY"),
+
+		TranscribeModel:        os.Getenv("TRANSCRIBE_MODEL"),
+		TranscribeLanguage:     os.Getenv("TRANSCRIBE_LANGUAGE"),
+		TranscribePrompt:       os.Getenv("TRANSCRIBE_PROMPT"),
+		HallucinationBlocklist: os.Getenv("HALLUCINATION_BLOCKLIST"),
 	}
 
 	if cfg.FWSBaseURL == "" {
 		cfg.FWSBaseURL = DefaultFWSBaseURL
 	}
+	if cfg.STTBackend == "" {
+		cfg.STTBackend = DefaultSTTBackend
+	}
+	if cfg.STTURL == "" {
+		switch cfg.STTBackend {
+		case "whisper.cpp":
+			cfg.STTURL = DefaultWhisperCpp
+		case "vosk":
+			cfg.STTURL = DefaultVoskURL
+		default:
+			cfg.STTURL = cfg.FWSBaseURL
+		}
+	}
+	if cfg.ChannelLayout == "" {
+		cfg.ChannelLayout = DefaultLayout
+	}
+	if cfg.SegmentMode == "" {
+		cfg.SegmentMode = DefaultSegmentMode
+	}
+	if cfg.VADMode == "" {
+		cfg.VADMode = DefaultVADMode
+	}
+	if cfg.AudioFilters == "" {
+		cfg.AudioFilters = DefaultFilters
+	}
+	if cfg.UploadFormat == "" {
+		cfg.UploadFormat = DefaultUploadFormat
+	}
+	if cfg.RecordingDir == "" {
+		cfg.RecordingDir = DefaultRecordDir
+	}
+	if cfg.RecordingMixFormat == "" {
+		cfg.RecordingMixFormat = DefaultMixFormat
+	}
+	if cfg.QueuePolicy == "" {
+		cfg.QueuePolicy = DefaultQueuePolicy
+	}
+	if cfg.TranscribeLanguage == "" {
+		cfg.TranscribeLanguage = DefaultLanguage
+	}
+	if cfg.HallucinationBlocklist == "" {
+		cfg.HallucinationBlocklist = DefaultBlocklist
+	}
+
+	var err error
+	if cfg.JitterDepth, err = intEnv("JITTER_BUFFER_FRAMES", DefaultJitterDepth, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.UploadSampleRate, err = intEnv("UPLOAD_SAMPLE_RATE", DefaultUploadRate, 8000); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxSegment, err = durationEnv("MAX_SEGMENT_DURATION", DefaultMaxSegment); err != nil {
+		return Config{}, err
+	}
+	if cfg.SplitWindow, err = durationEnv("SEGMENT_SPLIT_WINDOW", DefaultSplitWindow); err != nil {
+		return Config{}, err
+	}
+	if cfg.InterimInterval, err = durationEnv("INTERIM_INTERVAL", DefaultInterim); err != nil {
+		return Config{}, err
+	}
+	if cfg.SpeakingGrace, err = durationEnv("SPEAKING_GRACE", DefaultSpeaking); err != nil {
+		return Config{}, err
+	}
+	if cfg.PreRoll, err = durationEnv("PRE_ROLL", DefaultPreRoll); err != nil {
+		return Config{}, err
+	}
+	if cfg.PostRoll, err = durationEnv("POST_ROLL", DefaultPostRoll); err != nil {
+		return Config{}, err
+	}
+	if cfg.SegmentScore, err = LoadScoreThresholds(); err != nil {
+		return Config{}, err
+	}
+	if cfg.TranscribeWorkers, err = intEnv("TRANSCRIBE_WORKERS", DefaultWorkers, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.QueueDepth, err = intEnv("TRANSCRIBE_QUEUE_DEPTH", DefaultQueueDepth, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.TranscribeTemperature, err = optionalFloatEnv("TRANSCRIBE_TEMPERATURE"); err != nil {
+		return Config{}, err
+	}
+	if cfg.TranscribeBeamSize, err = intEnv("TRANSCRIBE_BEAM_SIZE", 0, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.TranscribeBestOf, err = intEnv("TRANSCRIBE_BEST_OF", 0, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.LanguagePinAfter, err = intEnv("LANGUAGE_PIN_AFTER", DefaultPinAfter, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.HallucinationMaxRepeats, err = intEnv("HALLUCINATION_MAX_REPEATS", DefaultMaxRepeats, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.HallucinationMaxCompression, err = floatEnv("HALLUCINATION_MAX_COMPRESSION", DefaultMaxCompressionRatio); err != nil {
+		return Config{}, err
+	}
+	if cfg.HallucinationMaxNoSpeech, err = floatEnv("HALLUCINATION_MAX_NO_SPEECH", DefaultMaxNoSpeechProb); err != nil {
+		return Config{}, err
+	}
+	if cfg.HallucinationMinLogprob, err = floatEnv("HALLUCINATION_MIN_LOGPROB", DefaultMinAvgLogprob); err != nil {
+		return Config{}, err
+	}
 
 	var missing []string
 	if cfg.DiscordToken == "" {
@@ -39,3 +260,83 @@ func Load() (Config, error) {
 
 	return cfg, nil
 }
+
+// LoadScoreThresholds reads the SEGMENT_* segment score thresholds from
+// environment variables, so tools other than the bot judge segments the same way.
+func LoadScoreThresholds() (audio.ScoreThresholds, error) {
+	var t audio.ScoreThresholds
+	var err error
+	if t.MinDuration, err = durationEnv("SEGMENT_MIN_DURATION", DefaultMinDuration); err != nil {
+		return audio.ScoreThresholds{}, err
+	}
+	if t.MinRMSDBFS, err = floatEnv("SEGMENT_MIN_RMS_DBFS", DefaultMinRMSDBFS); err != nil {
+		return audio.ScoreThresholds{}, err
+	}
+	if t.MinVoicedFraction, err = floatEnv("SEGMENT_MIN_VOICED", DefaultMinVoiced); err != nil {
+		return audio.ScoreThresholds{}, err
+	}
+	if t.MaxSpectralFlatness, err = floatEnv("SEGMENT_MAX_FLATNESS", DefaultMaxFlatness); err != nil {
+		return audio.ScoreThresholds{}, err
+	}
+	if t.MaxPeakToAverageDB, err = floatEnv("SEGMENT_MAX_PAR_DB", DefaultMaxPARDB); err != nil {
+		return audio.ScoreThresholds{}, err
+	}
+	return t, nil
+}
+
+// intEnv parses an integer environment variable, returning def when it is unset.
+func intEnv(key string, def, min int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if v < min {
+		return 0, fmt.Errorf("invalid %s: must be >= %d", key, min)
+	}
+	return v, nil
+}
+
+// floatEnv parses a floating point environment variable, returning def when it is unset.
+func floatEnv(key string, def float64) (float64, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// optionalFloatEnv parses a float environment variable, returning nil when it is unset.
+func optionalFloatEnv(key string) (*float64, error) {
+	if os.Getenv(key) == "" {
+		return nil, nil
+	}
+	v, err := floatEnv(key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// durationEnv parses a time.Duration environment variable such as "30s", returning def when it is unset.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return v, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -12,23 +13,40 @@ import (
 	"github.com/bwmarrin/discordgo"
 
 	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+	"github.com/pikachu0310/whisper-discord-bot/internal/config"
+	"github.com/pikachu0310/whisper-discord-bot/internal/stt"
 	"github.com/pikachu0310/whisper-discord-bot/internal/transcript"
-	"github.com/pikachu0310/whisper-discord-bot/internal/whisper"
 )
 
 const (
-	messageWindow       = 2 * time.Minute
-	silenceThreshold    = 1 * time.Second
-	minSegmentDuration  = 250 * time.Millisecond
-	minAverageAmplitude = 600
+	messageWindow      = 2 * time.Minute
+	silenceThreshold   = 1 * time.Second
+	queueStatsInterval = time.Minute
+	// uploadChannels is the channel count sent to Whisper; segments are
+	// downmixed before upload whatever the decode layout.
+	uploadChannels = 1
 )
 
 // Bot is the core Discord bot application.
 type Bot struct {
-	session              *discordgo.Session
-	whisperClient        *whisper.Client
-	aggregator           *transcript.Aggregator
-	transcriptChannelID  string
+	session             *discordgo.Session
+	transcriber         stt.Transcriber
+	aggregator          *transcript.Aggregator
+	transcriptChannelID string
+	receiverOptions     audio.ReceiverOptions
+	segmenterOptions    audio.SegmenterOptions
+	settings            *settingsStore
+	resampler           *audio.Resampler
+	uploadFormat        audio.UploadFormat
+	recordingDir        string
+	scoreThresholds     audio.ScoreThresholds
+	hallucinations      stt.HallucinationFilter
+	languages           *stt.LanguageTracker
+	mixFormat           audio.UploadFormat // empty disables the mixdown
+	queue               *audio.SegmentQueue
+
+	interimMu            sync.Mutex
+	interimInflight      map[uint64]struct{}
 	voiceMu              sync.Mutex
 	activeVoiceListeners map[string]*voiceHandler
 }
@@ -38,14 +56,63 @@ type voiceHandler struct {
 	cancel    context.CancelFunc
 	segmenter *audio.Segmenter
 	resolver  *ssrcResolver
+	receiver  *audio.Receiver
 }
 
 // New creates a ready-to-run bot.
-func New(token, transcriptChannelID string, whisperClient *whisper.Client) (*Bot, error) {
-	session, err := discordgo.New("Bot " + token)
+func New(cfg config.Config, transcriber stt.Transcriber) (*Bot, error) {
+	session, err := discordgo.New("Bot " + cfg.DiscordToken)
 	if err != nil {
 		return nil, fmt.Errorf("create discord session: %w", err)
 	}
+	segmentMode, ok := audio.ParseSegmentMode(cfg.SegmentMode)
+	if !ok {
+		return nil, fmt.Errorf("unknown segment mode %q", cfg.SegmentMode)
+	}
+	if _, ok := audio.ParseVAD(cfg.VADMode); !ok {
+		return nil, fmt.Errorf("unknown VAD mode %q", cfg.VADMode)
+	}
+	if _, err := audio.ParseFilterChain(cfg.AudioFilters); err != nil {
+		return nil, fmt.Errorf("parse audio filters: %w", err)
+	}
+	if cfg.UploadSampleRate > audio.SampleRate {
+		return nil, fmt.Errorf("upload sample rate %d exceeds capture rate %d", cfg.UploadSampleRate, audio.SampleRate)
+	}
+	uploadFormat, ok := audio.ParseUploadFormat(cfg.UploadFormat)
+	if !ok {
+		return nil, fmt.Errorf("unknown upload format %q", cfg.UploadFormat)
+	}
+	if !uploadFormat.Supports(cfg.UploadSampleRate) {
+		return nil, fmt.Errorf("upload format %s does not support %d Hz", uploadFormat, cfg.UploadSampleRate)
+	}
+	var mixFormat audio.UploadFormat
+	if cfg.RecordingMixFormat != "off" {
+		if mixFormat, ok = audio.ParseUploadFormat(cfg.RecordingMixFormat); !ok || mixFormat == audio.FormatFLAC {
+			return nil, fmt.Errorf("unknown recording mix format %q", cfg.RecordingMixFormat)
+		}
+	}
+	layout, ok := audio.ParseChannelLayout(cfg.ChannelLayout)
+	if !ok {
+		return nil, fmt.Errorf("unknown channel layout %q", cfg.ChannelLayout)
+	}
+	queuePolicy, ok := audio.ParseOverflowPolicy(cfg.QueuePolicy)
+	if !ok {
+		return nil, fmt.Errorf("unknown transcription queue policy %q", cfg.QueuePolicy)
+	}
+	language, err := parseLanguage(cfg.TranscribeLanguage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transcription language %q", cfg.TranscribeLanguage)
+	}
+	if t := cfg.TranscribeTemperature; t != nil && (*t < 0 || *t > 1) {
+		return nil, fmt.Errorf("transcription temperature %g out of range [0, 1]", *t)
+	}
+	if cfg.TranscribeBeamSize > maxBeam || cfg.TranscribeBestOf > maxBeam {
+		return nil, fmt.Errorf("transcription beam size and best-of must not exceed %d", maxBeam)
+	}
+	resampler, err := audio.NewResampler(audio.SampleRate, cfg.UploadSampleRate, uploadChannels)
+	if err != nil {
+		return nil, fmt.Errorf("create resampler: %w", err)
+	}
 	session.StateEnabled = true
 	session.Identify.Intents = discordgo.IntentsGuilds |
 		discordgo.IntentsGuildMessages |
@@ -53,12 +120,57 @@ func New(token, transcriptChannelID string, whisperClient *whisper.Client) (*Bot
 		discordgo.IntentsMessageContent
 
 	bot := &Bot{
-		session:              session,
-		whisperClient:        whisperClient,
-		transcriptChannelID:  transcriptChannelID,
+		session:             session,
+		transcriber:         transcriber,
+		transcriptChannelID: cfg.TranscriptChannelID,
+		resampler:           resampler,
+		uploadFormat:        uploadFormat,
+		recordingDir:        cfg.RecordingDir,
+		mixFormat:           mixFormat,
+		scoreThresholds:     cfg.SegmentScore,
+		hallucinations: stt.HallucinationFilter{
+			Blocklist:           stt.ParseBlocklist(cfg.HallucinationBlocklist),
+			MaxRepeats:          cfg.HallucinationMaxRepeats,
+			MaxCompressionRatio: cfg.HallucinationMaxCompression,
+			MaxNoSpeechProb:     cfg.HallucinationMaxNoSpeech,
+			MinAvgLogprob:       cfg.HallucinationMinLogprob,
+		},
+		languages: stt.NewLanguageTracker(cfg.LanguagePinAfter),
+		receiverOptions: audio.ReceiverOptions{
+			JitterDepth: cfg.JitterDepth,
+			Layout:      layout,
+		},
+		segmenterOptions: audio.SegmenterOptions{
+			Silence:         silenceThreshold,
+			Mode:            segmentMode,
+			MaxSegment:      cfg.MaxSegment,
+			SplitWindow:     cfg.SplitWindow,
+			InterimInterval: cfg.InterimInterval,
+			SpeakingGrace:   cfg.SpeakingGrace,
+			Channels:        layout.Channels(),
+			PreRoll:         cfg.PreRoll,
+			PostRoll:        cfg.PostRoll,
+		},
+		settings: newSettingsStore(guildSettings{
+			VAD:         cfg.VADMode,
+			Filters:     cfg.AudioFilters,
+			Model:       cfg.TranscribeModel,
+			Language:    language,
+			Prompt:      cfg.TranscribePrompt,
+			Temperature: cfg.TranscribeTemperature,
+			BeamSize:    cfg.TranscribeBeamSize,
+			BestOf:      cfg.TranscribeBestOf,
+		}),
 		activeVoiceListeners: make(map[string]*voiceHandler),
+		interimInflight:      make(map[uint64]struct{}),
 	}
-	bot.aggregator = transcript.NewAggregator(transcriptChannelID, transcript.DiscordPoster{Session: session}, messageWindow)
+	bot.queue = audio.NewSegmentQueue(audio.QueueOptions{
+		Workers: cfg.TranscribeWorkers,
+		Depth:   cfg.QueueDepth,
+		Policy:  queuePolicy,
+		OnDrop:  bot.dropSegment,
+	}, bot.consumeSegment)
+	bot.aggregator = transcript.NewAggregator(cfg.TranscriptChannelID, transcript.DiscordPoster{Session: session}, messageWindow)
 
 	session.AddHandler(bot.handleMessageCreate)
 
@@ -73,26 +185,63 @@ func (b *Bot) Run(ctx context.Context) error {
 	log.Println("bot is running")
 	defer b.session.Close()
 
+	go b.logQueueStats(ctx)
 	<-ctx.Done()
 	b.shutdown()
+	// Every receiver has flushed its speakers' last utterances by now.
+	b.queue.Drain()
 	return nil
 }
 
+// logQueueStats periodically logs the transcription queue's counters while
+// it is in use.
+func (b *Bot) logQueueStats(ctx context.Context) {
+	ticker := time.NewTicker(queueStatsInterval)
+	defer ticker.Stop()
+	var last audio.QueueStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats := b.queue.Stats()
+		if stats.Enqueued == last.Enqueued && stats.Depth == 0 && stats.Busy == 0 {
+			continue
+		}
+		log.Printf("transcription queue %s", stats)
+		last = stats
+	}
+}
+
 func (b *Bot) shutdown() {
 	b.voiceMu.Lock()
-	defer b.voiceMu.Unlock()
-
+	handlers := make([]*voiceHandler, 0, len(b.activeVoiceListeners))
 	for guildID, handler := range b.activeVoiceListeners {
-		handler.cancel()
-		if handler.segmenter != nil {
-			handler.segmenter.Stop()
-		}
-		if handler.conn != nil {
-			handler.conn.Disconnect()
-			handler.conn.Close()
-		}
+		handlers = append(handlers, handler)
 		delete(b.activeVoiceListeners, guildID)
 	}
+	b.voiceMu.Unlock()
+
+	for _, handler := range handlers {
+		handler.stop()
+	}
+}
+
+// stop ends a voice session and returns once its receiver has handed the
+// remaining audio to the segmenter and every segment has been enqueued.
+func (h *voiceHandler) stop() {
+	h.cancel()
+	if h.conn != nil {
+		h.conn.Disconnect()
+		h.conn.Close()
+	}
+	if h.receiver != nil {
+		<-h.receiver.Done()
+	}
+	if h.segmenter != nil {
+		h.segmenter.Stop()
+	}
 }
 
 func (b *Bot) handleMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -100,7 +249,9 @@ func (b *Bot) handleMessageCreate(s *discordgo.Session, m *discordgo.MessageCrea
 		return
 	}
 	log.Printf("[guild=%s channel=%s] command from %s: %s", m.GuildID, m.ChannelID, m.Author.ID, m.Content)
-	switch strings.TrimSpace(m.Content) {
+	content := strings.TrimSpace(m.Content)
+	command, args, _ := strings.Cut(content, " ")
+	switch command {
 	case "!join":
 		chID, err := b.findUserVoiceChannel(m.GuildID, m.Author.ID)
 		if err != nil {
@@ -120,35 +271,37 @@ func (b *Bot) handleMessageCreate(s *discordgo.Session, m *discordgo.MessageCrea
 			return
 		}
 		s.ChannelMessageSend(m.ChannelID, "退出しました。")
+	case "!config":
+		b.handleConfigCommand(s, m, args)
+	case "!lang":
+		b.handleLanguageCommand(s, m, args)
 	}
 }
 
 func (b *Bot) joinVoiceChannel(guildID, channelID string) error {
 	log.Printf("joining voice channel guild=%s channel=%s", guildID, channelID)
 	b.voiceMu.Lock()
-	if handler, ok := b.activeVoiceListeners[guildID]; ok {
-		if handler.conn != nil && handler.conn.ChannelID == channelID {
-			b.voiceMu.Unlock()
-			return nil
-		}
-		handler.cancel()
-		if handler.segmenter != nil {
-			handler.segmenter.Stop()
-		}
-		if handler.conn != nil {
-			handler.conn.Disconnect()
-			handler.conn.Close()
-		}
-		delete(b.activeVoiceListeners, guildID)
+	handler, ok := b.activeVoiceListeners[guildID]
+	if ok && handler.conn != nil && handler.conn.ChannelID == channelID {
+		b.voiceMu.Unlock()
+		return nil
 	}
+	delete(b.activeVoiceListeners, guildID)
 	b.voiceMu.Unlock()
+	if ok {
+		handler.stop()
+	}
 
 	vc, err := b.session.ChannelVoiceJoin(guildID, channelID, false, false)
 	if err != nil {
 		return err
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	segmenter := audio.NewSegmenter(guildID, silenceThreshold, b.consumeSegment)
+	settings := b.settings.get(guildID)
+	segmenterOptions := b.segmenterOptions
+	segmenterOptions.VAD, _ = audio.ParseVAD(settings.VAD)
+	segmenterOptions.Filters, _ = audio.ParseFilterChain(settings.Filters)
+	segmenter := audio.NewSegmenter(guildID, segmenterOptions, b.queue.Enqueue)
 	resolver := newSSRCResolver()
 	vc.LogLevel = discordgo.LogInformational
 	vc.AddSSRCMappingHandler(func(vc *discordgo.VoiceConnection, ssrc uint32, userID string) {
@@ -160,9 +313,23 @@ func (b *Bot) joinVoiceChannel(guildID, channelID string) error {
 			return
 		}
 		log.Printf("voice speaking update guild=%s user=%s speaking=%t ssrc=%d", vc.GuildID, vs.UserID, vs.Speaking, vs.SSRC)
+		segmenter.SetSpeaking(vs.UserID, vs.Speaking)
 	})
-	receiver := audio.NewReceiver(segmenter, resolver)
+	receiverOptions := b.receiverOptions
+	sessionDir := filepath.Join(b.recordingDir, guildID, time.Now().Format("20060102-150405"))
+	if settings.Record {
+		b.startRecording(guildID, channelID, sessionDir, &receiverOptions)
+	}
+	if settings.Capture {
+		b.startCapture(guildID, channelID, sessionDir, &receiverOptions)
+	}
+	receiver := audio.NewReceiver(segmenter, resolver, receiverOptions)
 	receiver.Start(ctx, vc)
+	// Registered after the receiver so it can still attribute queued packets while evicting.
+	vc.AddDisconnectHandler(func(vc *discordgo.VoiceConnection, userID string, ssrcs []uint32) {
+		resolver.remove(ssrcs)
+		log.Printf("voice client disconnect guild=%s user=%s ssrcs=%v", vc.GuildID, userID, ssrcs)
+	})
 	log.Printf("voice receiver started guild=%s channel=%s", guildID, channelID)
 
 	b.voiceMu.Lock()
@@ -171,12 +338,64 @@ func (b *Bot) joinVoiceChannel(guildID, channelID string) error {
 		cancel:    cancel,
 		segmenter: segmenter,
 		resolver:  resolver,
+		receiver:  receiver,
 	}
 	b.voiceMu.Unlock()
 
 	return nil
 }
 
+// startRecording sets up the per-user recorder and the mixdown of a new
+// recording session. Failures are logged and leave recording off so
+// transcription still proceeds.
+func (b *Bot) startRecording(guildID, channelID, dir string, opts *audio.ReceiverOptions) {
+	recorder, err := audio.NewRecorder(audio.RecorderOptions{
+		Dir:       dir,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		DisplayName: func(userID string) string {
+			return b.displayName(guildID, userID)
+		},
+	})
+	if err != nil {
+		log.Printf("recording disabled guild=%s: %v", guildID, err)
+		return
+	}
+	opts.Recorder = recorder
+	log.Printf("recording session guild=%s dir=%s", guildID, dir)
+
+	if b.mixFormat == "" {
+		return
+	}
+	mixer, err := audio.NewMixer(audio.MixerOptions{
+		Path:     filepath.Join(dir, "mix"+b.mixFormat.Extension()),
+		Format:   b.mixFormat,
+		Channels: b.receiverOptions.Layout.Channels(),
+	})
+	if err != nil {
+		log.Printf("recording mixdown disabled guild=%s: %v", guildID, err)
+		return
+	}
+	opts.Mixer = mixer
+}
+
+// startCapture records the raw packet stream and voice events of the session
+// for offline replay with cmd/replay. Failures are logged and leave capture off.
+func (b *Bot) startCapture(guildID, channelID, dir string, opts *audio.ReceiverOptions) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("capture disabled guild=%s: %v", guildID, err)
+		return
+	}
+	path := filepath.Join(dir, "capture.jsonl")
+	capture, err := audio.CreateCapture(path, guildID, channelID)
+	if err != nil {
+		log.Printf("capture disabled guild=%s: %v", guildID, err)
+		return
+	}
+	opts.Capture = capture
+	log.Printf("capturing voice packets guild=%s path=%s", guildID, path)
+}
+
 func (b *Bot) leaveVoiceChannel(guildID string) error {
 	b.voiceMu.Lock()
 	handler, ok := b.activeVoiceListeners[guildID]
@@ -188,14 +407,7 @@ func (b *Bot) leaveVoiceChannel(guildID string) error {
 		return fmt.Errorf("ボイスチャンネルに接続していません")
 	}
 
-	handler.cancel()
-	if handler.segmenter != nil {
-		handler.segmenter.Stop()
-	}
-	if handler.conn != nil {
-		handler.conn.Disconnect()
-		handler.conn.Close()
-	}
+	handler.stop()
 	return nil
 }
 
@@ -215,51 +427,170 @@ func (b *Bot) findUserVoiceChannel(guildID, userID string) (string, error) {
 	return "", fmt.Errorf("ユーザーは VC に接続していません")
 }
 
-func (b *Bot) consumeSegment(guildID, userID string, samples []int16) {
-	if len(samples) == 0 {
+func (b *Bot) consumeSegment(seg audio.Segment) {
+	if len(seg.Samples) == 0 {
+		return
+	}
+	if seg.Interim {
+		b.consumeInterim(seg)
 		return
 	}
-	if ok, reason := shouldSendSegment(samples); !ok {
-		log.Printf("segment skipped guild=%s user=%s (%s)", guildID, userID, reason)
+
+	defer b.finalizeMerged(seg)
+	key := utteranceKey(seg)
+	score := audio.ScoreSegment(audio.Downmix(seg.Speech(), seg.Channels), audio.SampleRate)
+	if ok, reason := b.scoreThresholds.Accept(score); !ok {
+		log.Printf("segment skipped guild=%s user=%s (%s) %s", seg.GuildID, seg.UserID, reason, score)
+		b.finalizeLine(seg.GuildID, key, "")
 		return
 	}
-	log.Printf("segment ready guild=%s user=%s samples=%d", guildID, userID, len(samples))
-	tmp, err := os.CreateTemp("", "segment-*.wav")
+	log.Printf("segment ready guild=%s user=%s samples=%d %s", seg.GuildID, seg.UserID, len(seg.Samples), score)
+
+	result, err := b.transcribe(seg)
 	if err != nil {
-		log.Printf("create temp file failed: %v", err)
+		log.Printf("transcription failed: %v", err)
+		b.finalizeLine(seg.GuildID, key, "")
+		return
+	}
+	logTranscription(seg, result)
+	result, drops := b.hallucinations.Apply(result)
+	for _, drop := range drops {
+		log.Printf("hallucination dropped guild=%s user=%s (%s) text=%q", seg.GuildID, seg.UserID, drop.Reason, drop.Text)
+	}
+	if result.Text == "" {
+		log.Printf("empty transcription guild=%s user=%s", seg.GuildID, seg.UserID)
+		b.finalizeLine(seg.GuildID, key, "")
+		return
+	}
+	settings := b.settings.get(seg.GuildID)
+	if settings.Language == "auto" && b.languages.Observe(seg.UserID, result, segmentDuration(len(seg.Speech()), seg.Channels)) {
+		log.Printf("language pinned guild=%s user=%s lang=%s", seg.GuildID, seg.UserID, result.Language)
+	}
+	b.finalizeLine(seg.GuildID, key, b.formatLine(seg, settings, result, false))
+}
+
+// finalizeMerged withdraws the provisional lines of utterances the queue
+// merged into seg; seg's own line carries their text.
+func (b *Bot) finalizeMerged(seg audio.Segment) {
+	for _, id := range seg.Merged {
+		merged := seg
+		merged.UtteranceID = id
+		b.finalizeLine(seg.GuildID, utteranceKey(merged), "")
+	}
+}
+
+// dropSegment is called for segments the transcription queue discards.
+func (b *Bot) dropSegment(seg audio.Segment) {
+	if seg.Interim {
+		return
+	}
+	log.Printf("segment dropped by full transcription queue guild=%s user=%s samples=%d %s", seg.GuildID, seg.UserID, len(seg.Samples), b.queue.Stats())
+	b.finalizeLine(seg.GuildID, utteranceKey(seg), "")
+	b.finalizeMerged(seg)
+}
+
+// consumeInterim transcribes a snapshot of an in-progress utterance and shows
+// it as a provisional line. Snapshots arriving while a previous one of the same
+// utterance is still being transcribed are skipped.
+func (b *Bot) consumeInterim(seg audio.Segment) {
+	b.interimMu.Lock()
+	if _, busy := b.interimInflight[seg.UtteranceID]; busy {
+		b.interimMu.Unlock()
 		return
 	}
+	b.interimInflight[seg.UtteranceID] = struct{}{}
+	b.interimMu.Unlock()
 	defer func() {
-		tmp.Close()
-		os.Remove(tmp.Name())
+		b.interimMu.Lock()
+		delete(b.interimInflight, seg.UtteranceID)
+		b.interimMu.Unlock()
 	}()
 
-	if err := audio.WritePCM16ToWAV(tmp.Name(), samples, audio.SampleRate, audio.Channels); err != nil {
-		log.Printf("write wav failed: %v", err)
+	if ok, _ := b.scoreThresholds.Accept(audio.ScoreSegment(audio.Downmix(seg.Speech(), seg.Channels), audio.SampleRate)); !ok {
+		return
+	}
+	result, err := b.transcribe(seg)
+	if err != nil {
+		log.Printf("interim transcription failed: %v", err)
 		return
 	}
+	// Interim snapshots are retranscribed soon, so their drops are not logged.
+	result, _ = b.hallucinations.Apply(result)
+	if result.Text == "" {
+		return
+	}
+	line := b.formatLine(seg, b.settings.get(seg.GuildID), result, true)
+	if err := b.aggregator.SetProvisional(utteranceKey(seg), line); err != nil {
+		log.Printf("aggregator provisional line failed: %v", err)
+	}
+}
+
+func (b *Bot) finalizeLine(guildID, key, line string) {
+	if err := b.aggregator.Finalize(key, line); err != nil {
+		log.Printf("aggregator finalize line failed: %v", err)
+		return
+	}
+	if line != "" {
+		log.Printf("posted transcription guild=%s line=%s", guildID, line)
+	}
+}
+
+// transcribe downmixes and resamples the segment to the upload rate and streams it to
+// Whisper in the upload format, retrying as WAV if that encoder fails.
+func (b *Bot) transcribe(seg audio.Segment) (stt.Result, error) {
+	samples := b.resampler.Resample(audio.Downmix(seg.Samples, seg.Channels))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	log.Printf("transcribing guild=%s user=%s file=%s", guildID, userID, tmp.Name())
-	text, err := b.whisperClient.Transcribe(ctx, tmp.Name())
+	opts := b.settings.get(seg.GuildID).transcribeOptions()
+	if opts.Language == "" {
+		opts.Language = b.languages.Language(seg.UserID)
+	}
+	log.Printf("transcribing guild=%s user=%s interim=%t samples=%d format=%s", seg.GuildID, seg.UserID, seg.Interim, len(samples), b.uploadFormat)
+	result, err := b.transcriber.Transcribe(ctx, stt.Audio{
+		Samples:    samples,
+		SampleRate: b.resampler.OutputRate(),
+		Channels:   uploadChannels,
+		Format:     b.uploadFormat,
+	}, opts)
 	if err != nil {
-		log.Printf("transcription failed: %v", err)
-		return
+		return stt.Result{}, err
 	}
-	text = strings.TrimSpace(text)
-	if text == "" {
-		log.Printf("empty transcription guild=%s user=%s", guildID, userID)
-		return
+	return result, nil
+}
+
+// logTranscription logs the timing and confidence of each segment the
+// backend decoded. Times are relative to the start of seg's speech.
+func logTranscription(seg audio.Segment, result stt.Result) {
+	lead := segmentDuration(seg.Lead, seg.Channels)
+	for _, s := range result.Segments {
+		log.Printf("transcribed guild=%s user=%s lang=%s %s-%s logprob=%.2f no_speech=%.2f compression=%.2f text=%q",
+			seg.GuildID, seg.UserID, result.Language, max(s.Start-lead, 0), max(s.End-lead, 0),
+			s.AvgLogprob, s.NoSpeechProb, s.CompressionRatio, s.Text)
 	}
-	displayName := b.displayName(guildID, userID)
-	line := fmt.Sprintf("%s: 「%s」", displayName, text)
-	if err := b.aggregator.AddLine(line); err != nil {
-		log.Printf("aggregator add line failed: %v", err)
-		return
+}
+
+// formatLine renders a transcript line, tagged with the language when the
+// guild asks for it.
+func (b *Bot) formatLine(seg audio.Segment, settings guildSettings, result stt.Result, interim bool) string {
+	speaker := b.displayName(seg.GuildID, seg.UserID)
+	if settings.LanguageTag && result.Language != "" {
+		speaker = fmt.Sprintf("%s [%s]", speaker, result.Language)
 	}
-	log.Printf("posted transcription guild=%s line=%s", guildID, line)
+	if interim {
+		return fmt.Sprintf("%s: 「%s」（認識中…）", speaker, result.Text)
+	}
+	return fmt.Sprintf("%s: 「%s」", speaker, result.Text)
+}
+
+// segmentDuration returns how long n interleaved samples of a segment last.
+func segmentDuration(n, channels int) time.Duration {
+	return time.Duration(n/max(channels, 1)) * time.Second / audio.SampleRate
+}
+
+func utteranceKey(seg audio.Segment) string {
+	return fmt.Sprintf("%s/%d", seg.GuildID, seg.UtteranceID)
 }
 
 func (b *Bot) displayName(guildID, userID string) string {
@@ -308,6 +639,14 @@ func (r *ssrcResolver) set(ssrc uint32, userID string) {
 	}
 }
 
+func (r *ssrcResolver) remove(ssrcs []uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ssrc := range ssrcs {
+		delete(r.mapping, ssrc)
+	}
+}
+
 func (r *ssrcResolver) Resolve(ssrc uint32) (string, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -350,26 +689,3 @@ func (r *ssrcResolver) Wait(ssrc uint32, timeout time.Duration) (string, bool) {
 		return "", false
 	}
 }
-
-func shouldSendSegment(samples []int16) (bool, string) {
-	if len(samples) == 0 {
-		return false, "no samples"
-	}
-	actualDuration := time.Duration(len(samples)) * time.Second / (time.Duration(audio.SampleRate) * time.Duration(audio.Channels))
-	if actualDuration < minSegmentDuration {
-		return false, fmt.Sprintf("duration %.2fs < %.2fs", actualDuration.Seconds(), minSegmentDuration.Seconds())
-	}
-	var sum int64
-	for _, sample := range samples {
-		if sample < 0 {
-			sum -= int64(sample)
-		} else {
-			sum += int64(sample)
-		}
-	}
-	avg := sum / int64(len(samples))
-	if avg < int64(minAverageAmplitude) {
-		return false, fmt.Sprintf("avg amplitude %d < %d", avg, minAverageAmplitude)
-	}
-	return true, ""
-}
@@ -0,0 +1,426 @@
+package discordbot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+	"github.com/pikachu0310/whisper-discord-bot/internal/stt"
+)
+
+// guildSettings holds per-guild options that can be changed with !config.
+type guildSettings struct {
+	VAD string
+	// Filters is the preprocessing filter chain spec accepted by audio.ParseFilterChain.
+	Filters string
+	// Record enables per-user session recording from the next !join.
+	Record bool
+	// Capture records the raw voice packet stream for replay from the next !join.
+	Capture bool
+	// Transcription options sent with every request. Model "", nil
+	// Temperature and zero numbers leave the server's default; Language is
+	// a code or "auto".
+	Model       string
+	Language    string
+	Prompt      string
+	Temperature *float64
+	BeamSize    int
+	BestOf      int
+	// LanguageTag prefixes transcript lines with the recognised language.
+	LanguageTag bool
+}
+
+// maxBeam bounds beam_size and best_of; larger values only slow decoding.
+const maxBeam = 10
+
+// transcribeOptions returns the options segments of the guild are
+// transcribed with.
+func (g guildSettings) transcribeOptions() stt.Options {
+	opts := stt.Options{
+		Model:       g.Model,
+		Language:    g.Language,
+		Prompt:      g.Prompt,
+		Temperature: g.Temperature,
+		BeamSize:    g.BeamSize,
+		BestOf:      g.BestOf,
+	}
+	if opts.Language == "auto" {
+		opts.Language = ""
+	}
+	return opts
+}
+
+// settingDef describes a single !config key.
+type settingDef struct {
+	description string
+	get         func(guildSettings) string
+	set         func(*guildSettings, string) error
+}
+
+var settingDefs = map[string]settingDef{
+	"vad": {
+		description: "音声区間検出 (off / energy / gmm)",
+		get:         func(g guildSettings) string { return g.VAD },
+		set: func(g *guildSettings, value string) error {
+			value = strings.ToLower(value)
+			if _, ok := audio.ParseVAD(value); !ok {
+				return fmt.Errorf("不明な VAD です: %s", value)
+			}
+			g.VAD = value
+			return nil
+		},
+	},
+	"filters": {
+		description: "前処理フィルタ (例: highpass:80,notch:50,agc,normalize:-1,gate:-50 / off)",
+		get:         func(g guildSettings) string { return g.Filters },
+		set: func(g *guildSettings, value string) error {
+			value = strings.ToLower(strings.ReplaceAll(value, " ", ""))
+			if _, err := audio.ParseFilterChain(value); err != nil {
+				return fmt.Errorf("フィルタ設定が不正です: %v", err)
+			}
+			g.Filters = value
+			return nil
+		},
+	},
+	"model": {
+		description: "文字起こしモデル (例: Systran/faster-whisper-large-v3 / default でサーバーの既定)",
+		get:         func(g guildSettings) string { return orDefault(g.Model, "default") },
+		set: func(g *guildSettings, value string) error {
+			if strings.ContainsAny(value, " \t") {
+				return fmt.Errorf("モデル名に空白は使えません: %s", value)
+			}
+			if value == "default" {
+				value = ""
+			}
+			g.Model = value
+			return nil
+		},
+	},
+	"language": {
+		description: "文字起こしの言語 (ja / en などの言語コード / auto で自動判定)",
+		get:         func(g guildSettings) string { return g.Language },
+		set: func(g *guildSettings, value string) error {
+			value, err := parseLanguage(value)
+			if err != nil {
+				return err
+			}
+			g.Language = value
+			return nil
+		},
+	},
+	"prompt": {
+		description: "固有名詞や表記を誘導する初期プロンプト (off で無し)",
+		get:         func(g guildSettings) string { return orDefault(g.Prompt, "off") },
+		set: func(g *guildSettings, value string) error {
+			if value == "off" {
+				value = ""
+			}
+			g.Prompt = value
+			return nil
+		},
+	},
+	"temperature": {
+		description: "サンプリング温度 (0〜1、0 で最も確実な候補のみ / default でサーバーの既定)",
+		get:         func(g guildSettings) string { return formatTemperature(g.Temperature) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseTemperature(value)
+			if err != nil {
+				return err
+			}
+			g.Temperature = v
+			return nil
+		},
+	},
+	"beam_size": {
+		description: fmt.Sprintf("ビームサーチの幅 (1〜%d / 0 でサーバーの既定)", maxBeam),
+		get:         func(g guildSettings) string { return formatBeam(g.BeamSize) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseBeam(value)
+			if err != nil {
+				return err
+			}
+			g.BeamSize = v
+			return nil
+		},
+	},
+	"best_of": {
+		description: fmt.Sprintf("温度が 0 より大きいときに比較する候補数 (1〜%d / 0 でサーバーの既定)", maxBeam),
+		get:         func(g guildSettings) string { return formatBeam(g.BestOf) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseBeam(value)
+			if err != nil {
+				return err
+			}
+			g.BestOf = v
+			return nil
+		},
+	},
+	"language_tag": {
+		description: "文字起こし行に認識した言語を表示 (on / off)",
+		get:         func(g guildSettings) string { return formatBool(g.LanguageTag) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseBool(value)
+			if err != nil {
+				return err
+			}
+			g.LanguageTag = v
+			return nil
+		},
+	},
+	"capture": {
+		description: "デバッグ用の受信パケットキャプチャ (on / off、次回の !join から反映)",
+		get:         func(g guildSettings) string { return formatBool(g.Capture) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseBool(value)
+			if err != nil {
+				return err
+			}
+			g.Capture = v
+			return nil
+		},
+	},
+	"record": {
+		description: "VC の録音 (on / off、次回の !join から反映)",
+		get:         func(g guildSettings) string { return formatBool(g.Record) },
+		set: func(g *guildSettings, value string) error {
+			v, err := parseBool(value)
+			if err != nil {
+				return err
+			}
+			g.Record = v
+			return nil
+		},
+	},
+}
+
+func formatBool(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true, nil
+	case "off", "false", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("on または off を指定してください: %s", value)
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// parseLanguage accepts "auto" or an ISO 639 code such as "ja" or "en".
+func parseLanguage(value string) (string, error) {
+	value = strings.ToLower(value)
+	if value == "auto" {
+		return value, nil
+	}
+	if len(value) < 2 || len(value) > 3 || strings.Trim(value, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return "", fmt.Errorf("言語コード (ja / en など) または auto を指定してください: %s", value)
+	}
+	return value, nil
+}
+
+func parseTemperature(value string) (*float64, error) {
+	if value == "default" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 || v > 1 {
+		return nil, fmt.Errorf("0〜1 の数値または default を指定してください: %s", value)
+	}
+	return &v, nil
+}
+
+func formatTemperature(v *float64) string {
+	if v == nil {
+		return "default"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func parseBeam(value string) (int, error) {
+	if value == "default" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 || v > maxBeam {
+		return 0, fmt.Errorf("0〜%d の整数を指定してください: %s", maxBeam, value)
+	}
+	return v, nil
+}
+
+func formatBeam(v int) string {
+	if v == 0 {
+		return "default"
+	}
+	return strconv.Itoa(v)
+}
+
+type settingsStore struct {
+	mu       sync.Mutex
+	defaults guildSettings
+	guilds   map[string]guildSettings
+}
+
+func newSettingsStore(defaults guildSettings) *settingsStore {
+	return &settingsStore{
+		defaults: defaults,
+		guilds:   make(map[string]guildSettings),
+	}
+}
+
+func (s *settingsStore) get(guildID string) guildSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if settings, ok := s.guilds[guildID]; ok {
+		return settings
+	}
+	return s.defaults
+}
+
+func (s *settingsStore) set(guildID, key, value string) (guildSettings, error) {
+	def, ok := settingDefs[key]
+	if !ok {
+		return guildSettings{}, fmt.Errorf("不明な設定項目です: %s", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, ok := s.guilds[guildID]
+	if !ok {
+		settings = s.defaults
+	}
+	if err := def.set(&settings, value); err != nil {
+		return guildSettings{}, err
+	}
+	s.guilds[guildID] = settings
+	return settings, nil
+}
+
+func formatSettings(settings guildSettings) string {
+	keys := make([]string, 0, len(settingDefs))
+	for key := range settingDefs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("現在の設定:")
+	for _, key := range keys {
+		def := settingDefs[key]
+		fmt.Fprintf(&sb, "\n- `%s` = `%s` … %s", key, def.get(settings), def.description)
+	}
+	return sb.String()
+}
+
+// handleConfigCommand implements "!config" (show) and "!config <key> <value>"
+// (update) for members who can manage the guild.
+func (b *Bot) handleConfigCommand(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	allowed, err := canManageGuild(s, m)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("権限を確認できません: %v", err))
+		return
+	}
+	if !allowed {
+		s.ChannelMessageSend(m.ChannelID, "`!config` にはサーバー管理権限が必要です。")
+		return
+	}
+
+	key, value, _ := strings.Cut(strings.TrimSpace(args), " ")
+	key = strings.ToLower(key)
+	value = strings.TrimSpace(value)
+	if key == "" {
+		s.ChannelMessageSend(m.ChannelID, formatSettings(b.settings.get(m.GuildID)))
+		return
+	}
+	if value == "" {
+		s.ChannelMessageSend(m.ChannelID, "使い方: `!config <項目> <値>`")
+		return
+	}
+
+	settings, err := b.settings.set(m.GuildID, key, value)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("設定に失敗しました: %v", err))
+		return
+	}
+	log.Printf("guild settings updated guild=%s %s=%s", m.GuildID, key, value)
+	b.applySettings(m.GuildID, settings)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("設定を更新しました: `%s` = `%s`", key, settingDefs[key].get(settings)))
+}
+
+// canManageGuild reports whether the author of m has the Manage Server or
+// Administrator permission in the channel the message was sent to.
+func canManageGuild(s *discordgo.Session, m *discordgo.MessageCreate) (bool, error) {
+	perms, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
+		return false, err
+	}
+	return perms&(discordgo.PermissionManageGuild|discordgo.PermissionAdministrator) != 0, nil
+}
+
+// handleLanguageCommand implements "!lang" (show), "!lang <code>" (override)
+// and "!lang auto" (forget and detect again) for the sender.
+func (b *Bot) handleLanguageCommand(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	value := strings.TrimSpace(args)
+	if value == "" {
+		lang, source := b.languages.Status(m.Author.ID)
+		var status string
+		switch source {
+		case stt.LanguageOverride:
+			status = fmt.Sprintf("`%s`（手動で指定）", lang)
+		case stt.LanguagePinned:
+			status = fmt.Sprintf("`%s`（自動判定で確定）", lang)
+		default:
+			status = "自動判定中"
+		}
+		if guildLang := b.settings.get(m.GuildID).Language; guildLang != "auto" {
+			status += fmt.Sprintf("。ただしこのサーバーは `%s` 固定のため、`!config language auto` のときのみ使われます", guildLang)
+		}
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> の言語: %s", m.Author.ID, status))
+		return
+	}
+
+	lang, err := parseLanguage(value)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("設定に失敗しました: %v", err))
+		return
+	}
+	if lang == "auto" {
+		b.languages.SetOverride(m.Author.ID, "")
+		log.Printf("user language reset user=%s", m.Author.ID)
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> の言語を自動判定に戻しました。", m.Author.ID))
+		return
+	}
+	b.languages.SetOverride(m.Author.ID, lang)
+	log.Printf("user language set user=%s lang=%s", m.Author.ID, lang)
+	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("<@%s> の言語を `%s` に設定しました。", m.Author.ID, lang))
+}
+
+// applySettings pushes settings that can change mid-session to the active voice handler.
+func (b *Bot) applySettings(guildID string, settings guildSettings) {
+	b.voiceMu.Lock()
+	handler, ok := b.activeVoiceListeners[guildID]
+	b.voiceMu.Unlock()
+	if !ok || handler.segmenter == nil {
+		return
+	}
+	vad, _ := audio.ParseVAD(settings.VAD)
+	handler.segmenter.SetVAD(vad)
+	filters, _ := audio.ParseFilterChain(settings.Filters)
+	handler.segmenter.SetFilters(filters)
+}
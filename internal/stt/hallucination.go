@@ -0,0 +1,257 @@
+package stt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultBlocklist holds phrases Whisper is known to produce on silence and
+// noise, learnt from video subtitles.
+var DefaultBlocklist = []string{
+	"ご視聴ありがとうございました",
+	"ご視聴ありがとうございます",
+	"最後までご視聴いただきありがとうございました",
+	"チャンネル登録お願いします",
+	"チャンネル登録よろしくお願いします",
+	"チャンネル登録と高評価よろしくお願いします",
+	"高評価とチャンネル登録よろしくお願いします",
+	"次回もお楽しみに",
+	"字幕視聴ありがとうございました",
+	"thank you for watching",
+	"thanks for watching",
+	"please subscribe",
+}
+
+// Default hallucination filter thresholds. The compression and no-speech
+// values are the ones Whisper itself uses to reject decodes.
+const (
+	DefaultMaxRepeats          = 8
+	DefaultMaxCompressionRatio = 2.4
+	DefaultMaxNoSpeechProb     = 0.6
+	DefaultMinAvgLogprob       = -1.0
+)
+
+// maxRepeatUnit is the longest phrase, in runes, checked for repetition loops.
+const maxRepeatUnit = 16
+
+// A single character repeated back to back is usually laughter ("はははは",
+// "wwww") or a drawn-out sound rather than a loop. It only counts as one when
+// the run is singleRuneRepeatFactor times longer than MaxRepeats allows and
+// covers at least loopCoverage of the text.
+const (
+	singleRuneRepeatFactor = 4
+	loopCoverage           = 0.8
+)
+
+// HallucinationFilter removes text Whisper made up rather than heard. It
+// works per segment so that one bad span does not cost the whole line;
+// results without segments are judged on their text alone.
+type HallucinationFilter struct {
+	// Blocklist phrases are matched against whole segments, ignoring case,
+	// spaces and punctuation.
+	Blocklist []string
+	// MaxRepeats drops segments in which one phrase repeats back to back
+	// more than this many times. Zero disables the check.
+	MaxRepeats int
+	// MaxCompressionRatio drops segments whose text compresses better than
+	// this, a sign of repetition. It is computed locally when the backend
+	// does not report it. Zero disables the check.
+	MaxCompressionRatio float64
+	// Segments with a no-speech probability above MaxNoSpeechProb and an
+	// average log probability below MinAvgLogprob are dropped as silence.
+	// Zero MaxNoSpeechProb disables the check.
+	MaxNoSpeechProb float64
+	MinAvgLogprob   float64
+}
+
+// DefaultHallucinationFilter returns a filter with the default blocklist and
+// thresholds.
+func DefaultHallucinationFilter() HallucinationFilter {
+	return HallucinationFilter{
+		Blocklist:           DefaultBlocklist,
+		MaxRepeats:          DefaultMaxRepeats,
+		MaxCompressionRatio: DefaultMaxCompressionRatio,
+		MaxNoSpeechProb:     DefaultMaxNoSpeechProb,
+		MinAvgLogprob:       DefaultMinAvgLogprob,
+	}
+}
+
+// ParseBlocklist parses a comma-separated list of phrases. "default" expands
+// to DefaultBlocklist and "off" yields an empty list.
+func ParseBlocklist(s string) []string {
+	if strings.TrimSpace(s) == "off" {
+		return nil
+	}
+	var phrases []string
+	for _, phrase := range strings.Split(s, ",") {
+		switch phrase = strings.TrimSpace(phrase); phrase {
+		case "":
+		case "default":
+			phrases = append(phrases, DefaultBlocklist...)
+		default:
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// Drop is text the filter removed.
+type Drop struct {
+	Text   string
+	Reason string
+}
+
+// Apply returns r without its hallucinated segments, and what it removed.
+// The returned Text is empty when nothing survived.
+func (f HallucinationFilter) Apply(r Result) (Result, []Drop) {
+	if len(r.Segments) == 0 {
+		if reason := f.check(Segment{Text: r.Text}); reason != "" {
+			return Result{Language: r.Language, LanguageProbability: r.LanguageProbability}, []Drop{{Text: r.Text, Reason: reason}}
+		}
+		return r, nil
+	}
+
+	var drops []Drop
+	kept := r.Segments[:0:0]
+	for _, s := range r.Segments {
+		if reason := f.check(s); reason != "" {
+			drops = append(drops, Drop{Text: s.Text, Reason: reason})
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if len(drops) == 0 {
+		return r, nil
+	}
+	out := Result{Language: r.Language, LanguageProbability: r.LanguageProbability, Segments: kept}
+	for _, s := range kept {
+		out.Text = joinText(out.Text, s.Text)
+		out.Words = append(out.Words, wordsWithin(r.Words, s)...)
+	}
+	return out, drops
+}
+
+// check returns why s should be dropped, or "" to keep it.
+func (f HallucinationFilter) check(s Segment) string {
+	normalized := normalize(s.Text)
+	if normalized == "" {
+		return ""
+	}
+	for _, phrase := range f.Blocklist {
+		if normalized == normalize(phrase) {
+			return fmt.Sprintf("blocklisted phrase %q", phrase)
+		}
+	}
+	if f.MaxNoSpeechProb > 0 && s.NoSpeechProb > f.MaxNoSpeechProb && s.AvgLogprob < f.MinAvgLogprob {
+		return fmt.Sprintf("no_speech_prob %.2f > %.2f with avg_logprob %.2f < %.2f", s.NoSpeechProb, f.MaxNoSpeechProb, s.AvgLogprob, f.MinAvgLogprob)
+	}
+	if f.MaxRepeats > 0 {
+		if unit, n, ok := repetitionLoop([]rune(normalized), f.MaxRepeats); ok {
+			return fmt.Sprintf("%q repeated %d times > %d", unit, n, f.MaxRepeats)
+		}
+	}
+	if f.MaxCompressionRatio > 0 {
+		ratio := s.CompressionRatio
+		if ratio == 0 {
+			ratio = compressionRatio(s.Text)
+		}
+		if ratio > f.MaxCompressionRatio {
+			return fmt.Sprintf("compression ratio %.2f > %.2f", ratio, f.MaxCompressionRatio)
+		}
+	}
+	return ""
+}
+
+// normalize lowercases s and strips spaces and punctuation so that
+// "ご視聴ありがとうございました。" matches its blocklist entry.
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// repetitionLoop finds the phrase of up to maxRepeatUnit runes repeated back
+// to back the most times in runes, among those repeated often enough to be a
+// loop rather than speech.
+func repetitionLoop(runes []rune, maxRepeats int) (unit string, count int, ok bool) {
+	best, bestSize := 0, 0
+	for size := 1; size <= min(maxRepeatUnit, len(runes)/2); size++ {
+		for start := 0; start+2*size <= len(runes); start++ {
+			n := 1
+			for next := start + size; next+size <= len(runes) && equalRunes(runes, start, next, size); next += size {
+				n++
+			}
+			if n > count && isLoop(size, n, len(runes), maxRepeats) {
+				best, bestSize, count = start, size, n
+			}
+		}
+	}
+	if count == 0 {
+		return "", 0, false
+	}
+	return string(runes[best : best+bestSize]), count, true
+}
+
+// isLoop reports whether a size-rune unit repeated n times in a text of
+// total runes is a repetition loop.
+func isLoop(size, n, total, maxRepeats int) bool {
+	if n <= maxRepeats {
+		return false
+	}
+	if size >= 2 {
+		return true
+	}
+	return n > singleRuneRepeatFactor*maxRepeats && float64(n) >= loopCoverage*float64(total)
+}
+
+// equalRunes reports whether the size runes at a and b are the same.
+func equalRunes(runes []rune, a, b, size int) bool {
+	for i := range size {
+		if runes[a+i] != runes[b+i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compressionRatio is the ratio of the UTF-8 length of text to its zlib
+// compressed length, as Whisper computes it.
+func compressionRatio(text string) float64 {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(text))
+	w.Close()
+	return float64(len(text)) / float64(buf.Len())
+}
+
+// joinText appends next to text, separating them with a space only between
+// words of space-delimited scripts.
+func joinText(text, next string) string {
+	if text == "" || next == "" {
+		return text + next
+	}
+	last, _ := utf8.DecodeLastRuneInString(text)
+	first, _ := utf8.DecodeRuneInString(next)
+	if last < utf8.RuneSelf && first < utf8.RuneSelf && !unicode.IsSpace(last) && !unicode.IsSpace(first) {
+		return text + " " + next
+	}
+	return text + next
+}
+
+// wordsWithin returns the words that start inside s.
+func wordsWithin(words []Word, s Segment) []Word {
+	var out []Word
+	for _, w := range words {
+		if w.Start >= s.Start && w.Start < s.End {
+			out = append(out, w)
+		}
+	}
+	return out
+}
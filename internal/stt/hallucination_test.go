@@ -0,0 +1,73 @@
+package stt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHallucinationFilterDropsSegments(t *testing.T) {
+	f := DefaultHallucinationFilter()
+	result := Result{
+		Text:     "こんにちは ご視聴ありがとうございました。",
+		Language: "ja",
+		Segments: []Segment{
+			{Start: 0, End: time.Second, Text: "こんにちは", AvgLogprob: -0.3},
+			{Start: time.Second, End: 2 * time.Second, Text: "ご視聴ありがとうございました。", AvgLogprob: -0.2},
+			{Start: 2 * time.Second, End: 3 * time.Second, Text: "えっと", NoSpeechProb: 0.8, AvgLogprob: -1.2},
+			{Start: 3 * time.Second, End: 4 * time.Second, Text: "元気?", NoSpeechProb: 0.8, AvgLogprob: -0.4},
+		},
+		Words: []Word{
+			{Start: 100 * time.Millisecond, Text: "こんにちは"},
+			{Start: 1100 * time.Millisecond, Text: "ご視聴"},
+			{Start: 3100 * time.Millisecond, Text: "元気"},
+		},
+	}
+	got, drops := f.Apply(result)
+	if got.Text != "こんにちは元気?" || got.Language != "ja" || len(got.Segments) != 2 {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if words := []string{got.Words[0].Text, got.Words[1].Text}; len(got.Words) != 2 || !reflect.DeepEqual(words, []string{"こんにちは", "元気"}) {
+		t.Fatalf("unexpected words %+v", got.Words)
+	}
+	if len(drops) != 2 || !strings.HasPrefix(drops[0].Reason, "blocklisted") || !strings.HasPrefix(drops[1].Reason, "no_speech_prob") {
+		t.Fatalf("unexpected drops %+v", drops)
+	}
+}
+
+func TestHallucinationFilterRepetition(t *testing.T) {
+	f := DefaultHallucinationFilter()
+	loop := strings.Repeat("ありがとう", 12)
+	if _, drops := f.Apply(Result{Text: loop}); len(drops) != 1 || !strings.Contains(drops[0].Reason, `"ありがとう" repeated 12 times`) {
+		t.Fatalf("expected a repetition drop, got %+v", drops)
+	}
+	// Loops the repeat check misses still compress too well.
+	f.MaxRepeats = 0
+	if _, drops := f.Apply(Result{Text: loop}); len(drops) != 1 || !strings.HasPrefix(drops[0].Reason, "compression ratio") {
+		t.Fatalf("expected a compression drop, got %+v", drops)
+	}
+	// A single character drawn out over the whole segment is a loop too.
+	if _, drops := DefaultHallucinationFilter().Apply(Result{Text: strings.Repeat("あ", 40)}); len(drops) != 1 || !strings.Contains(drops[0].Reason, `"あ" repeated 40 times`) {
+		t.Fatalf("expected a single-rune loop drop, got %+v", drops)
+	}
+	for _, text := range []string{"はははは、それは面白いね", "どうもどうも、よろしくお願いします", "ははははははははは", "wwwwwwwww", "それなｗｗｗｗｗｗｗｗｗｗ"} {
+		if got, drops := DefaultHallucinationFilter().Apply(Result{Text: text}); got.Text != text || len(drops) != 0 {
+			t.Fatalf("dropped ordinary speech %q: %+v", text, drops)
+		}
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	if got := ParseBlocklist("off"); got != nil {
+		t.Fatalf("expected no phrases, got %v", got)
+	}
+	got := ParseBlocklist("default, おつかれさまでした ,")
+	if len(got) != len(DefaultBlocklist)+1 || got[len(got)-1] != "おつかれさまでした" {
+		t.Fatalf("unexpected blocklist %v", got)
+	}
+	f := HallucinationFilter{Blocklist: ParseBlocklist("Thanks for watching")}
+	if _, drops := f.Apply(Result{Text: " thanks for watching! "}); len(drops) != 1 {
+		t.Fatal("expected the blocklist to ignore case and punctuation")
+	}
+}
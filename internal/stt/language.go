@@ -0,0 +1,189 @@
+package stt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// languageNames maps the language names OpenAI-style servers report to
+// ISO 639-1 codes.
+var languageNames = map[string]string{
+	"english":    "en",
+	"japanese":   "ja",
+	"chinese":    "zh",
+	"korean":     "ko",
+	"german":     "de",
+	"spanish":    "es",
+	"french":     "fr",
+	"italian":    "it",
+	"portuguese": "pt",
+	"russian":    "ru",
+	"dutch":      "nl",
+	"polish":     "pl",
+	"turkish":    "tr",
+	"arabic":     "ar",
+	"hindi":      "hi",
+	"indonesian": "id",
+	"vietnamese": "vi",
+	"thai":       "th",
+	"ukrainian":  "uk",
+	"swedish":    "sv",
+	"cantonese":  "yue",
+	"tagalog":    "tl",
+}
+
+// LanguageCode returns the ISO 639-1 code for a language reported as a code
+// or an English name such as "japanese". Unknown names are returned
+// lowercased.
+func LanguageCode(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if code, ok := languageNames[language]; ok {
+		return code
+	}
+	return language
+}
+
+// Thresholds for counting a detection towards pinning a user's language.
+// Short clips are where Whisper misdetects most.
+const (
+	minDetectionSpeech     = 2 * time.Second
+	minLanguageProbability = 0.8
+	minDetectionAvgLogprob = -0.6
+)
+
+// LanguageSource says where a user's language comes from.
+type LanguageSource int
+
+const (
+	// LanguageDetected means the language is still detected per segment.
+	LanguageDetected LanguageSource = iota
+	// LanguagePinned means enough confident detections agreed.
+	LanguagePinned
+	// LanguageOverride means the user chose the language.
+	LanguageOverride
+)
+
+// LanguageTracker remembers the language detected for each user and pins
+// it once PinAfter confident detections in a row agree, so short clips are
+// no longer misdetected. It is safe for concurrent use.
+type LanguageTracker struct {
+	pinAfter int
+
+	mu    sync.Mutex
+	users map[string]*userLanguage
+}
+
+type userLanguage struct {
+	override string
+	pinned   string
+	// last is the most recent confident detection and streak how many
+	// detections in a row agreed with it.
+	last   string
+	streak int
+}
+
+// NewLanguageTracker returns a tracker that pins after pinAfter agreeing
+// detections. Zero never pins.
+func NewLanguageTracker(pinAfter int) *LanguageTracker {
+	return &LanguageTracker{pinAfter: pinAfter, users: make(map[string]*userLanguage)}
+}
+
+// Language returns the language to request for userID, or "" to let the
+// backend detect it.
+func (t *LanguageTracker) Language(userID string) string {
+	lang, _ := t.Status(userID)
+	return lang
+}
+
+// Status returns userID's language and where it comes from. The language
+// is "" while it is still detected per segment.
+func (t *LanguageTracker) Status(userID string) (string, LanguageSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.users[userID]
+	switch {
+	case !ok:
+		return "", LanguageDetected
+	case u.override != "":
+		return u.override, LanguageOverride
+	case u.pinned != "":
+		return u.pinned, LanguagePinned
+	default:
+		return "", LanguageDetected
+	}
+}
+
+// Observe records the language detected in r for speech of the given
+// length. It reports whether this detection pinned the user's language.
+func (t *LanguageTracker) Observe(userID string, r Result, speech time.Duration) bool {
+	if r.Language == "" || !confidentDetection(r, speech) {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.users[userID]
+	if u == nil {
+		u = &userLanguage{}
+		t.users[userID] = u
+	}
+	if u.override != "" || u.pinned != "" {
+		return false
+	}
+	if r.Language == u.last {
+		u.streak++
+	} else {
+		u.last, u.streak = r.Language, 1
+	}
+	if t.pinAfter > 0 && u.streak >= t.pinAfter {
+		u.pinned = u.last
+		return true
+	}
+	return false
+}
+
+// SetOverride fixes userID's language. "" clears the override and
+// everything learnt, so detection starts over.
+func (t *LanguageTracker) SetOverride(userID, language string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if language == "" {
+		delete(t.users, userID)
+		return
+	}
+	t.users[userID] = &userLanguage{override: language}
+}
+
+// confidentDetection reports whether r's language can be trusted: the
+// backend's probability when it reports one, otherwise how sure it was of
+// the text it decoded in that language.
+func confidentDetection(r Result, speech time.Duration) bool {
+	if speech < minDetectionSpeech {
+		return false
+	}
+	if r.LanguageProbability > 0 {
+		return r.LanguageProbability >= minLanguageProbability
+	}
+	if len(r.Segments) == 0 {
+		return false
+	}
+	var sum float64
+	for _, s := range r.Segments {
+		sum += s.AvgLogprob
+	}
+	return sum/float64(len(r.Segments)) >= minDetectionAvgLogprob
+}
+
+func (s LanguageSource) String() string {
+	switch s {
+	case LanguageDetected:
+		return "detected"
+	case LanguagePinned:
+		return "pinned"
+	case LanguageOverride:
+		return "override"
+	default:
+		return fmt.Sprintf("LanguageSource(%d)", int(s))
+	}
+}
@@ -0,0 +1,75 @@
+package stt
+
+import (
+	"testing"
+	"time"
+)
+
+func detection(lang string, probability float64) Result {
+	return Result{Text: "text", Language: lang, LanguageProbability: probability}
+}
+
+func TestLanguageTrackerPinsAfterAgreeingDetections(t *testing.T) {
+	tracker := NewLanguageTracker(3)
+	observe := func(r Result, speech time.Duration) bool {
+		t.Helper()
+		return tracker.Observe("u", r, speech)
+	}
+
+	observe(detection("ja", 0.95), 3*time.Second)
+	// Short clips and unsure detections do not count either way.
+	observe(detection("en", 0.95), time.Second)
+	observe(detection("en", 0.5), 3*time.Second)
+	observe(detection("ja", 0.9), 3*time.Second)
+	if lang, source := tracker.Status("u"); lang != "" || source != LanguageDetected {
+		t.Fatalf("pinned too early: %q %s", lang, source)
+	}
+	if !observe(detection("ja", 0.9), 3*time.Second) {
+		t.Fatal("expected the third agreeing detection to pin")
+	}
+	if lang, source := tracker.Status("u"); lang != "ja" || source != LanguagePinned {
+		t.Fatalf("unexpected status %q %s", lang, source)
+	}
+	if observe(detection("en", 0.99), 3*time.Second) || tracker.Language("u") != "ja" {
+		t.Fatal("a pinned language must not change")
+	}
+}
+
+func TestLanguageTrackerDisagreementRestartsStreak(t *testing.T) {
+	tracker := NewLanguageTracker(2)
+	tracker.Observe("u", detection("ja", 0.9), 3*time.Second)
+	tracker.Observe("u", detection("en", 0.9), 3*time.Second)
+	if tracker.Language("u") != "" {
+		t.Fatal("disagreeing detections must not pin")
+	}
+	// Without a reported probability, decode confidence decides.
+	sure := Result{Language: "en", Segments: []Segment{{AvgLogprob: -0.2}, {AvgLogprob: -0.4}}}
+	unsure := Result{Language: "ja", Segments: []Segment{{AvgLogprob: -1.2}}}
+	tracker.Observe("u", unsure, 3*time.Second)
+	if !tracker.Observe("u", sure, 3*time.Second) {
+		t.Fatal("expected the second confident en detection to pin")
+	}
+}
+
+func TestLanguageTrackerOverride(t *testing.T) {
+	tracker := NewLanguageTracker(1)
+	tracker.SetOverride("u", "en")
+	if tracker.Observe("u", detection("ja", 1), 5*time.Second) {
+		t.Fatal("detections must not pin over an override")
+	}
+	if lang, source := tracker.Status("u"); lang != "en" || source != LanguageOverride {
+		t.Fatalf("unexpected status %q %s", lang, source)
+	}
+	tracker.SetOverride("u", "")
+	if lang, source := tracker.Status("u"); lang != "" || source != LanguageDetected {
+		t.Fatalf("expected detection to restart, got %q %s", lang, source)
+	}
+}
+
+func TestLanguageCode(t *testing.T) {
+	for in, want := range map[string]string{"japanese": "ja", "English": "en", "ja": "ja", "": ""} {
+		if got := LanguageCode(in); got != want {
+			t.Fatalf("LanguageCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package stt abstracts the speech-to-text engines the bot can send
+// segments to.
+package stt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+)
+
+// Backend names accepted by New.
+const (
+	BackendFasterWhisper = "faster-whisper"
+	BackendWhisperCpp    = "whisper.cpp"
+	BackendVosk          = "vosk"
+)
+
+// Transcriber turns a segment of speech into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio Audio, opts Options) (Result, error)
+}
+
+// Audio is the input of a transcription: PCM, or an already encoded file
+// for backends that accept files.
+type Audio struct {
+	// Samples are interleaved PCM16 at SampleRate.
+	Samples    []int16
+	SampleRate int
+	Channels   int
+	// Format is the file format Samples are encoded in for backends that
+	// upload files. Defaults to WAV; backends fall back to WAV when the
+	// encoder fails.
+	Format audio.UploadFormat
+
+	// File, when set, is sent as is instead of Samples. Filename's
+	// extension tells the server its format.
+	File     io.Reader
+	Filename string
+}
+
+// Options are per-request recognition parameters. Backends ignore the ones
+// they do not support.
+type Options struct {
+	// Model selects the model on servers that host several.
+	Model string
+	// Language is an ISO-639-1 code such as "ja". Empty asks the backend to
+	// detect it.
+	Language string
+	// Prompt guides spelling and style.
+	Prompt string
+	// Temperature, BeamSize and BestOf tune decoding; nil and zero leave
+	// the server's default.
+	Temperature *float64
+	BeamSize    int
+	BestOf      int
+	// Words requests word timings from backends that only produce them
+	// on demand.
+	Words bool
+}
+
+// Result is a transcription.
+type Result struct {
+	Text string
+	// Language is the ISO 639-1 code of the language the backend
+	// recognised, when it reports one.
+	Language string
+	// LanguageProbability is the backend's confidence in Language, or zero
+	// when it does not report one.
+	LanguageProbability float64
+	// Segments and Words carry timings relative to the start of the audio
+	// when the backend provides them.
+	Segments []Segment
+	Words    []Word
+}
+
+// Segment is a timed span of the transcript. The confidence fields are
+// zero when the backend does not report them.
+type Segment struct {
+	Start, End time.Duration
+	Text       string
+	// AvgLogprob is the mean token log probability.
+	AvgLogprob float64
+	// NoSpeechProb is the probability that the span has no speech.
+	NoSpeechProb float64
+	// CompressionRatio is the gzip ratio of Text; high values mean the
+	// model repeated itself.
+	CompressionRatio float64
+}
+
+// Word is a single recognised word.
+type Word struct {
+	Start, End time.Duration
+	Text       string
+	// Probability is the backend's confidence in the word, from 0 to 1.
+	Probability float64
+}
+
+// New returns the Transcriber for backend talking to the server at url.
+func New(backend, url string) (Transcriber, error) {
+	switch backend {
+	case BackendFasterWhisper:
+		return NewFasterWhisper(url), nil
+	case BackendWhisperCpp:
+		return NewWhisperCpp(url), nil
+	case BackendVosk:
+		return NewVosk(url), nil
+	default:
+		return nil, fmt.Errorf("unknown speech-to-text backend %q", backend)
+	}
+}
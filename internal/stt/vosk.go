@@ -0,0 +1,128 @@
+package stt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+)
+
+// voskChunk is how much audio is sent per websocket message.
+const voskChunk = 500 * time.Millisecond
+
+// Vosk transcribes with a Vosk/Kaldi websocket server (vosk-server's
+// asr_server). The language and model are fixed by the server, and it has
+// no decoding parameters, so Options are ignored.
+type Vosk struct {
+	url    string
+	dialer *websocket.Dialer
+}
+
+// NewVosk returns a Vosk for the server at url, e.g. "ws://localhost:2700".
+func NewVosk(url string) *Vosk {
+	return &Vosk{url: url, dialer: websocket.DefaultDialer}
+}
+
+type voskConfig struct {
+	Config struct {
+		SampleRate int `json:"sample_rate"`
+		Words      int `json:"words"`
+	} `json:"config"`
+}
+
+type voskResponse struct {
+	Text   *string `json:"text"`
+	Result []struct {
+		Conf  float64 `json:"conf"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Word  string  `json:"word"`
+	} `json:"result"`
+}
+
+// Transcribe implements Transcriber. Only PCM input is supported.
+func (v *Vosk) Transcribe(ctx context.Context, a Audio, _ Options) (Result, error) {
+	if a.File != nil {
+		return Result{}, errors.New("vosk backend needs PCM, not an encoded file")
+	}
+	samples := audio.Downmix(a.Samples, a.Channels)
+
+	conn, _, err := v.dialer.DialContext(ctx, v.url, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to vosk: %w", err)
+	}
+	defer conn.Close()
+	// Unblock reads and writes once ctx ends.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var cfg voskConfig
+	cfg.Config.SampleRate = a.SampleRate
+	cfg.Config.Words = 1
+	if err := conn.WriteJSON(cfg); err != nil {
+		return Result{}, fmt.Errorf("send vosk config: %w", err)
+	}
+
+	var result Result
+	var texts []string
+	collect := func() error {
+		var resp voskResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read vosk result: %w", err)
+		}
+		if resp.Text == nil {
+			return nil // partial result
+		}
+		text := strings.TrimSpace(*resp.Text)
+		if text != "" {
+			texts = append(texts, text)
+		}
+		first := len(result.Words)
+		for _, w := range resp.Result {
+			result.Words = append(result.Words, Word{
+				Start:       time.Duration(w.Start * float64(time.Second)),
+				End:         time.Duration(w.End * float64(time.Second)),
+				Text:        w.Word,
+				Probability: w.Conf,
+			})
+		}
+		// Each final result is an utterance; time it by its words.
+		if words := result.Words[first:]; text != "" && len(words) > 0 {
+			result.Segments = append(result.Segments, Segment{Start: words[0].Start, End: words[len(words)-1].End, Text: text})
+		}
+		return nil
+	}
+
+	// The server answers every audio message with a partial or final result.
+	chunk := max(int(voskChunk*time.Duration(a.SampleRate)/time.Second), 1)
+	buf := make([]byte, 0, 2*chunk)
+	for start := 0; start < len(samples); start += chunk {
+		buf = buf[:0]
+		for _, s := range samples[start:min(start+chunk, len(samples))] {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(s))
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+			return Result{}, fmt.Errorf("send vosk audio: %w", err)
+		}
+		if err := collect(); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"eof":1}`)); err != nil {
+		return Result{}, fmt.Errorf("send vosk eof: %w", err)
+	}
+	if err := collect(); err != nil {
+		return Result{}, err
+	}
+	result.Text = strings.Join(texts, " ")
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeVosk emulates vosk-server: a partial result per audio message, a
+// final result once a second of audio has arrived, and the rest on eof.
+func fakeVosk(t *testing.T) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		var cfg voskConfig
+		if err := conn.ReadJSON(&cfg); err != nil || cfg.Config.SampleRate != 16000 {
+			t.Errorf("unexpected config %+v: %v", cfg, err)
+			return
+		}
+		var received int
+		final := false
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind == websocket.TextMessage {
+				conn.WriteJSON(map[string]any{"text": "せかい", "result": []map[string]any{
+					{"conf": 0.9, "start": 1.0, "end": 1.4, "word": "せかい"},
+				}})
+				return
+			}
+			received += len(data) / 2
+			if received >= cfg.Config.SampleRate && !final {
+				final = true
+				conn.WriteJSON(map[string]any{"text": "こんにちは", "result": []map[string]any{
+					{"conf": 1.0, "start": 0.1, "end": 0.6, "word": "こんにちは"},
+				}})
+				continue
+			}
+			conn.WriteJSON(map[string]string{"partial": "こん"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVoskStreamsPCM(t *testing.T) {
+	server := fakeVosk(t)
+	v := NewVosk("ws" + strings.TrimPrefix(server.URL, "http"))
+	result, err := v.Transcribe(context.Background(), Audio{
+		Samples:    make([]int16, 2*24000),
+		SampleRate: 16000,
+		Channels:   2,
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "こんにちは せかい" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+	if len(result.Words) != 2 || result.Words[1].Start != time.Second || result.Words[0].Probability != 1 {
+		words, _ := json.Marshal(result.Words)
+		t.Fatalf("unexpected words %s", words)
+	}
+	if len(result.Segments) != 2 || result.Segments[1].Start != time.Second || result.Segments[1].End != 1400*time.Millisecond {
+		t.Fatalf("unexpected segments %+v", result.Segments)
+	}
+}
+
+func TestVoskRejectsEncodedFiles(t *testing.T) {
+	_, err := NewVosk("ws://localhost:1").Transcribe(context.Background(), Audio{File: strings.NewReader("x"), Filename: "a.ogg"}, Options{})
+	if err == nil {
+		t.Fatal("expected an error for encoded input")
+	}
+}
@@ -0,0 +1,122 @@
+package stt
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+	"github.com/pikachu0310/whisper-discord-bot/internal/whisper"
+)
+
+// whisperCppRate is the only sample rate the whisper.cpp server accepts
+// without ffmpeg conversion.
+const whisperCppRate = 16000
+
+// FasterWhisper transcribes with faster-whisper-server's OpenAI-compatible API.
+type FasterWhisper struct {
+	client *whisper.Client
+}
+
+// NewFasterWhisper returns a FasterWhisper for the server at baseURL.
+func NewFasterWhisper(baseURL string) *FasterWhisper {
+	return &FasterWhisper{client: whisper.New(baseURL)}
+}
+
+// Transcribe implements Transcriber.
+func (f *FasterWhisper) Transcribe(ctx context.Context, a Audio, opts Options) (Result, error) {
+	format := a.Format
+	if format == "" {
+		format = audio.FormatWAV
+	}
+	t, err := uploadFile(ctx, f.client, a, format, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(t), nil
+}
+
+// WhisperCpp transcribes with the whisper.cpp example server. PCM is always
+// uploaded as 16kHz WAV, which the server reads without ffmpeg.
+type WhisperCpp struct {
+	client *whisper.Client
+}
+
+// NewWhisperCpp returns a WhisperCpp for the server at baseURL.
+func NewWhisperCpp(baseURL string) *WhisperCpp {
+	return &WhisperCpp{client: whisper.NewWhisperCpp(baseURL)}
+}
+
+// Transcribe implements Transcriber.
+func (w *WhisperCpp) Transcribe(ctx context.Context, a Audio, opts Options) (Result, error) {
+	if a.File == nil && a.SampleRate != whisperCppRate {
+		resampler, err := audio.NewResampler(a.SampleRate, whisperCppRate, a.Channels)
+		if err != nil {
+			return Result{}, err
+		}
+		a.Samples = resampler.Resample(a.Samples)
+		a.SampleRate = whisperCppRate
+	}
+	t, err := uploadFile(ctx, w.client, a, audio.FormatWAV, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(t), nil
+}
+
+// uploadFile sends a's file, or its samples encoded in format, retrying as
+// WAV if that encoder fails.
+func uploadFile(ctx context.Context, client *whisper.Client, a Audio, format audio.UploadFormat, opts Options) (whisper.Transcription, error) {
+	params := whisper.Options{
+		Model:       opts.Model,
+		Language:    opts.Language,
+		Prompt:      opts.Prompt,
+		Temperature: opts.Temperature,
+		BeamSize:    opts.BeamSize,
+		BestOf:      opts.BestOf,
+		Verbose:     true,
+		Words:       opts.Words,
+	}
+	if a.File != nil {
+		return client.Transcribe(ctx, a.Filename, func(w io.Writer) error {
+			_, err := io.Copy(w, a.File)
+			return err
+		}, params)
+	}
+
+	var encodeErr error
+	t, err := client.Transcribe(ctx, "segment"+format.Extension(), func(w io.Writer) error {
+		encodeErr = audio.EncodeSegment(w, format, a.Samples, a.SampleRate, a.Channels)
+		return encodeErr
+	}, params)
+	if err != nil && encodeErr != nil && format != audio.FormatWAV {
+		log.Printf("encode %s failed, falling back to wav: %v", format, encodeErr)
+		return uploadFile(ctx, client, a, audio.FormatWAV, opts)
+	}
+	return t, err
+}
+
+// result converts a Whisper response, trimming the leading spaces Whisper
+// puts before each segment.
+func result(t whisper.Transcription) Result {
+	r := Result{
+		Text:                strings.TrimSpace(t.Text),
+		Language:            LanguageCode(t.Language),
+		LanguageProbability: t.LanguageProbability,
+	}
+	for _, s := range t.Segments {
+		r.Segments = append(r.Segments, Segment{
+			Start:            s.Start,
+			End:              s.End,
+			Text:             strings.TrimSpace(s.Text),
+			AvgLogprob:       s.AvgLogprob,
+			NoSpeechProb:     s.NoSpeechProb,
+			CompressionRatio: s.CompressionRatio,
+		})
+	}
+	for _, w := range t.Words {
+		r.Words = append(r.Words, Word{Start: w.Start, End: w.End, Text: strings.TrimSpace(w.Word), Probability: w.Probability})
+	}
+	return r
+}
@@ -0,0 +1,85 @@
+package stt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pikachu0310/whisper-discord-bot/internal/audio"
+)
+
+// wavServer answers with text after checking the uploaded WAV's format.
+func wavServer(t *testing.T, path string, wantRate, wantSamples int, text string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("read form file: %v", err)
+			return
+		}
+		defer file.Close()
+		wav, err := audio.ReadWAV(file)
+		if err != nil {
+			t.Errorf("read %s: %v", header.Filename, err)
+			return
+		}
+		if wav.SampleRate != wantRate || wav.Channels != 1 || len(wav.Samples) != wantSamples {
+			t.Errorf("unexpected upload rate=%d channels=%d samples=%d", wav.SampleRate, wav.Channels, len(wav.Samples))
+		}
+		if lang := r.FormValue("language"); lang != "ja" {
+			t.Errorf("unexpected language %q", lang)
+		}
+		if format := r.FormValue("response_format"); format != "verbose_json" {
+			t.Errorf("unexpected response format %q", format)
+		}
+		w.Write([]byte(`{"text":" ` + text + ` ","language":"ja","segments":[{"start":0.0,"end":0.1,"text":" ` + text + `","avg_logprob":-0.3,"no_speech_prob":0.05}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFasterWhisperDefaultsToWAV(t *testing.T) {
+	server := wavServer(t, "/v1/audio/transcriptions", 24000, 2400, "こんにちは")
+	result, err := NewFasterWhisper(server.URL).Transcribe(context.Background(), Audio{
+		Samples:    make([]int16, 2400),
+		SampleRate: 24000,
+		Channels:   1,
+	}, Options{Language: "ja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "こんにちは" || result.Language != "ja" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	seg := result.Segments[0]
+	if seg.Text != "こんにちは" || seg.End != 100*time.Millisecond || seg.AvgLogprob != -0.3 || seg.NoSpeechProb != 0.05 {
+		t.Fatalf("unexpected segment %+v", seg)
+	}
+}
+
+func TestWhisperCppResamplesTo16k(t *testing.T) {
+	server := wavServer(t, "/inference", whisperCppRate, 1600, "テスト")
+	result, err := NewWhisperCpp(server.URL).Transcribe(context.Background(), Audio{
+		Samples:    make([]int16, 4800),
+		SampleRate: audio.SampleRate,
+		Channels:   1,
+		Format:     audio.FormatOggOpus,
+	}, Options{Language: "ja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "テスト" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+}
+
+func TestNewRejectsUnknownBackend(t *testing.T) {
+	if _, err := New("deepspeech", "http://localhost"); err == nil {
+		t.Fatal("expected an error for an unknown backend")
+	}
+}
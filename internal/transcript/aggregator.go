@@ -11,10 +11,11 @@ import (
 
 const maxDiscordMessageLength = 2000
 
-// Poster abstracts Discord message send/edit operations for testing.
+// Poster abstracts Discord message send/edit/delete operations for testing.
 type Poster interface {
 	SendMessage(channelID, content string) (string, error)
 	EditMessage(channelID, messageID, content string) error
+	DeleteMessage(channelID, messageID string) error
 }
 
 // DiscordPoster implements Poster using a discordgo session.
@@ -43,14 +44,31 @@ func (p DiscordPoster) EditMessage(channelID, messageID, content string) error {
 	return err
 }
 
+// DeleteMessage deletes a Discord message.
+func (p DiscordPoster) DeleteMessage(channelID, messageID string) error {
+	if p.Session == nil {
+		return fmt.Errorf("session is nil")
+	}
+	return p.Session.ChannelMessageDelete(channelID, messageID)
+}
+
+// maxClosedKeys bounds how many finalized keys are remembered to reject stale provisional updates.
+const maxClosedKeys = 1024
+
+type messageLine struct {
+	key  string // empty for lines that can not be replaced
+	text string
+}
+
 type messageState struct {
 	id      string
-	lines   []string
+	lines   []messageLine
 	content string
 	timer   *time.Timer
 }
 
 // Aggregator batches transcription lines into a single Discord message with a timeout.
+// Lines posted with SetProvisional are replaced in place by Finalize.
 type Aggregator struct {
 	channelID string
 	poster    Poster
@@ -58,6 +76,10 @@ type Aggregator struct {
 
 	mu      sync.Mutex
 	current *messageState
+
+	keyed       map[string]*messageState
+	closed      map[string]struct{}
+	closedOrder []string
 }
 
 // NewAggregator creates an Aggregator.
@@ -66,6 +88,8 @@ func NewAggregator(channelID string, poster Poster, window time.Duration) *Aggre
 		channelID: channelID,
 		poster:    poster,
 		window:    window,
+		keyed:     make(map[string]*messageState),
+		closed:    make(map[string]struct{}),
 	}
 }
 
@@ -79,29 +103,81 @@ func (a *Aggregator) AddLine(line string) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	for _, chunk := range splitLine(line) {
-		if a.current == nil {
-			if err := a.startNewMessageLocked(chunk); err != nil {
-				return err
-			}
-			continue
+	return a.addLineLocked(line)
+}
+
+// SetProvisional shows line as the provisional text for key, replacing the
+// previous provisional text in place. Updates for a finalized key are ignored.
+func (a *Aggregator) SetProvisional(key, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	line = truncateLine(line)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, done := a.closed[key]; done {
+		return nil
+	}
+	if state, ok := a.keyed[key]; ok {
+		if idx := state.indexOf(key); idx >= 0 && a.replaceFitsLocked(state, idx, line) {
+			return a.replaceLocked(state, idx, messageLine{key: key, text: line})
 		}
+		if err := a.removeKeyLocked(state, key); err != nil {
+			return err
+		}
+	}
+	return a.addChunkLocked(messageLine{key: key, text: line})
+}
 
-		if a.wouldExceedLimitLocked(chunk) {
-			a.finalizeCurrentLocked()
-			if err := a.startNewMessageLocked(chunk); err != nil {
-				return err
-			}
-			continue
+// Finalize replaces the provisional text for key with line, or appends line
+// when no provisional text exists. An empty line removes the provisional text.
+func (a *Aggregator) Finalize(key, line string) error {
+	line = strings.TrimSpace(line)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closeKeyLocked(key)
+	state, ok := a.keyed[key]
+	if ok {
+		idx := state.indexOf(key)
+		if line != "" && idx >= 0 && len([]rune(line)) <= maxDiscordMessageLength && a.replaceFitsLocked(state, idx, line) {
+			delete(a.keyed, key)
+			return a.replaceLocked(state, idx, messageLine{text: line})
 		}
+		if err := a.removeKeyLocked(state, key); err != nil {
+			return err
+		}
+	}
+	if line == "" {
+		return nil
+	}
+	return a.addLineLocked(line)
+}
 
-		if err := a.appendToCurrentLocked(chunk); err != nil {
+func (a *Aggregator) addLineLocked(line string) error {
+	for _, chunk := range splitLine(line) {
+		if err := a.addChunkLocked(messageLine{text: chunk}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (a *Aggregator) addChunkLocked(line messageLine) error {
+	if a.current == nil {
+		return a.startNewMessageLocked(line)
+	}
+	if a.wouldExceedLimitLocked(line.text) {
+		a.finalizeCurrentLocked()
+		return a.startNewMessageLocked(line)
+	}
+	return a.appendToCurrentLocked(line)
+}
+
 func (a *Aggregator) resetTimerLocked(state *messageState) {
 	if state.timer != nil {
 		state.timer.Stop()
@@ -116,35 +192,100 @@ func (a *Aggregator) resetTimerLocked(state *messageState) {
 	})
 }
 
-func (a *Aggregator) startNewMessageLocked(line string) error {
-	msgID, err := a.poster.SendMessage(a.channelID, line)
+func (a *Aggregator) startNewMessageLocked(line messageLine) error {
+	msgID, err := a.poster.SendMessage(a.channelID, line.text)
 	if err != nil {
 		return err
 	}
 	state := &messageState{
 		id:      msgID,
-		lines:   []string{line},
-		content: line,
+		lines:   []messageLine{line},
+		content: line.text,
 	}
 	a.current = state
+	a.trackLocked(state, line)
 	a.resetTimerLocked(state)
 	return nil
 }
 
-func (a *Aggregator) appendToCurrentLocked(line string) error {
-	newContent := line
+func (a *Aggregator) appendToCurrentLocked(line messageLine) error {
+	newContent := line.text
 	if a.current.content != "" {
-		newContent = strings.Join([]string{a.current.content, line}, "\n")
+		newContent = strings.Join([]string{a.current.content, line.text}, "\n")
 	}
 	if err := a.poster.EditMessage(a.channelID, a.current.id, newContent); err != nil {
 		return err
 	}
 	a.current.lines = append(a.current.lines, line)
 	a.current.content = newContent
+	a.trackLocked(a.current, line)
 	a.resetTimerLocked(a.current)
 	return nil
 }
 
+// replaceLocked swaps the line at idx of state and edits the message.
+func (a *Aggregator) replaceLocked(state *messageState, idx int, line messageLine) error {
+	lines := append([]messageLine(nil), state.lines...)
+	lines[idx] = line
+	content := renderLines(lines)
+	if err := a.poster.EditMessage(a.channelID, state.id, content); err != nil {
+		return err
+	}
+	state.lines = lines
+	state.content = content
+	return nil
+}
+
+// removeKeyLocked drops the line for key from state, deleting the message when it becomes empty.
+func (a *Aggregator) removeKeyLocked(state *messageState, key string) error {
+	delete(a.keyed, key)
+	idx := state.indexOf(key)
+	if idx < 0 {
+		return nil
+	}
+	lines := append(append([]messageLine(nil), state.lines[:idx]...), state.lines[idx+1:]...)
+	if len(lines) == 0 {
+		if err := a.poster.DeleteMessage(a.channelID, state.id); err != nil {
+			return err
+		}
+		if a.current == state {
+			a.finalizeCurrentLocked()
+		}
+		state.lines = nil
+		state.content = ""
+		return nil
+	}
+	content := renderLines(lines)
+	if err := a.poster.EditMessage(a.channelID, state.id, content); err != nil {
+		return err
+	}
+	state.lines = lines
+	state.content = content
+	return nil
+}
+
+func (a *Aggregator) replaceFitsLocked(state *messageState, idx int, text string) bool {
+	return len(state.content)-len(state.lines[idx].text)+len(text) <= maxDiscordMessageLength
+}
+
+func (a *Aggregator) trackLocked(state *messageState, line messageLine) {
+	if line.key != "" {
+		a.keyed[line.key] = state
+	}
+}
+
+func (a *Aggregator) closeKeyLocked(key string) {
+	if _, ok := a.closed[key]; ok {
+		return
+	}
+	a.closed[key] = struct{}{}
+	a.closedOrder = append(a.closedOrder, key)
+	if len(a.closedOrder) > maxClosedKeys {
+		delete(a.closed, a.closedOrder[0])
+		a.closedOrder = a.closedOrder[1:]
+	}
+}
+
 func (a *Aggregator) finalizeCurrentLocked() {
 	if a.current == nil {
 		return
@@ -166,6 +307,32 @@ func (a *Aggregator) wouldExceedLimitLocked(line string) bool {
 	return len(a.current.content)+extra > maxDiscordMessageLength
 }
 
+func (s *messageState) indexOf(key string) int {
+	for i, line := range s.lines {
+		if line.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func renderLines(lines []messageLine) string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// truncateLine shortens line to fit in a single Discord message.
+func truncateLine(line string) string {
+	runes := []rune(line)
+	if len(runes) <= maxDiscordMessageLength {
+		return line
+	}
+	return string(runes[:maxDiscordMessageLength-1]) + "…"
+}
+
 func splitLine(line string) []string {
 	runes := []rune(line)
 	if len(runes) <= maxDiscordMessageLength {
@@ -9,6 +9,7 @@ import (
 type mockPoster struct {
 	sentMessages  []string
 	editedContent []string
+	deleted       []string
 }
 
 func (m *mockPoster) SendMessage(channelID, content string) (string, error) {
@@ -21,6 +22,21 @@ func (m *mockPoster) EditMessage(channelID, messageID, content string) error {
 	return nil
 }
 
+func (m *mockPoster) DeleteMessage(channelID, messageID string) error {
+	m.deleted = append(m.deleted, messageID)
+	return nil
+}
+
+func (m *mockPoster) lastContent() string {
+	if len(m.editedContent) > 0 {
+		return m.editedContent[len(m.editedContent)-1]
+	}
+	if len(m.sentMessages) > 0 {
+		return m.sentMessages[len(m.sentMessages)-1]
+	}
+	return ""
+}
+
 func TestAggregatorAddLine(t *testing.T) {
 	poster := &mockPoster{}
 	agg := NewAggregator("chan", poster, 20*time.Millisecond)
@@ -74,3 +90,65 @@ func TestAggregatorSplitsLongMessages(t *testing.T) {
 		t.Fatalf("expected new send when limit exceeded, got %d", len(poster2.sentMessages))
 	}
 }
+
+func TestAggregatorProvisionalLineReplacedInPlace(t *testing.T) {
+	poster := &mockPoster{}
+	agg := NewAggregator("chan", poster, time.Minute)
+
+	if err := agg.AddLine("first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := agg.SetProvisional("u1", "second…"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := agg.AddLine("third"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := agg.SetProvisional("u1", "second draft…"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := poster.lastContent(), "first\nsecond draft…\nthird"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if err := agg.Finalize("u1", "second final"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := poster.lastContent(), "first\nsecond final\nthird"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	// A late interim result must not resurrect a finalized line.
+	edits := len(poster.editedContent)
+	if err := agg.SetProvisional("u1", "stale…"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(poster.editedContent) != edits {
+		t.Fatalf("expected stale provisional update to be ignored")
+	}
+	if len(poster.sentMessages) != 1 {
+		t.Fatalf("expected a single message, got %d", len(poster.sentMessages))
+	}
+}
+
+func TestAggregatorFinalizeEmptyRemovesProvisional(t *testing.T) {
+	poster := &mockPoster{}
+	agg := NewAggregator("chan", poster, time.Minute)
+
+	if err := agg.SetProvisional("u1", "noise…"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := agg.Finalize("u1", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(poster.deleted) != 1 {
+		t.Fatalf("expected provisional-only message to be deleted, got %d deletes", len(poster.deleted))
+	}
+
+	if err := agg.AddLine("next"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(poster.sentMessages) != 2 {
+		t.Fatalf("expected a new message after deletion, got %d sends", len(poster.sentMessages))
+	}
+}
@@ -1,7 +1,7 @@
 package whisper
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,77 +10,290 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
-const defaultTimeout = 2 * time.Minute
+const (
+	defaultTimeout = 2 * time.Minute
 
-// Client talks to faster-whisper-server using an OpenAI-compatible API.
+	openAIPath     = "/v1/audio/transcriptions"
+	whisperCppPath = "/inference"
+)
+
+// Client talks to a Whisper server that accepts multipart uploads and
+// answers with JSON: faster-whisper-server's OpenAI-compatible API or the
+// whisper.cpp example server.
 type Client struct {
 	baseURL    string
+	path       string
 	httpClient *http.Client
 }
 
-// New creates a new Client with the provided baseURL.
+// Options are the per-request parameters sent with the audio. Zero values
+// are not sent, leaving the server's defaults.
+type Options struct {
+	// Model names the model to use, e.g. "Systran/faster-whisper-large-v3".
+	// whisper.cpp serves the model it was started with and ignores it.
+	Model string
+	// Language is an ISO-639-1 code such as "ja". Empty lets the server
+	// detect it.
+	Language string
+	// Prompt is an initial prompt that guides spelling and style.
+	Prompt string
+	// Temperature is the sampling temperature; zero decodes greedily and nil
+	// leaves the server's default.
+	Temperature *float64
+	// BeamSize is the beam search width and BestOf the number of candidates
+	// sampled at non-zero temperatures.
+	BeamSize int
+	BestOf   int
+	// Verbose requests verbose_json, which adds per-segment timings and
+	// confidence to the response.
+	Verbose bool
+	// Words additionally requests word timings. It implies Verbose.
+	Words bool
+}
+
+// Transcription is a server response. Everything but Text is only filled
+// for verbose requests.
+type Transcription struct {
+	Text     string
+	Language string
+	Duration time.Duration
+	Segments []Segment
+	// Words are the word timings of the whole transcription.
+	Words []Word
+	// LanguageProbability is the confidence of the detected language, for
+	// servers that report it.
+	LanguageProbability float64
+}
+
+// Segment is a span of the transcription as the model decoded it.
+type Segment struct {
+	ID         int
+	Start, End time.Duration
+	Text       string
+	// AvgLogprob is the mean token log probability; values below about -1
+	// suggest a poor decode.
+	AvgLogprob float64
+	// NoSpeechProb is the model's probability that the span has no speech.
+	NoSpeechProb float64
+	// CompressionRatio is the gzip ratio of the text; values above about
+	// 2.4 suggest repetition loops.
+	CompressionRatio float64
+	Temperature      float64
+}
+
+// Word is a single word with its timing.
+type Word struct {
+	Start, End  time.Duration
+	Word        string
+	Probability float64
+}
+
+// response is the JSON body of both response formats. Times are seconds.
+// The detected_* fields are whisper.cpp's names for the language.
+type response struct {
+	Text                        string  `json:"text"`
+	Language                    string  `json:"language"`
+	LanguageProbability         float64 `json:"language_probability"`
+	DetectedLanguage            string  `json:"detected_language"`
+	DetectedLanguageProbability float64 `json:"detected_language_probability"`
+	Duration                    float64 `json:"duration"`
+	Segments                    []struct {
+		ID               int            `json:"id"`
+		Start            float64        `json:"start"`
+		End              float64        `json:"end"`
+		Text             string         `json:"text"`
+		AvgLogprob       float64        `json:"avg_logprob"`
+		NoSpeechProb     float64        `json:"no_speech_prob"`
+		CompressionRatio float64        `json:"compression_ratio"`
+		Temperature      float64        `json:"temperature"`
+		Words            []responseWord `json:"words"`
+	} `json:"segments"`
+	Words []responseWord `json:"words"`
+}
+
+type responseWord struct {
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Word        string  `json:"word"`
+	Probability float64 `json:"probability"`
+}
+
+// New creates a Client for faster-whisper-server's OpenAI-compatible API at baseURL.
 func New(baseURL string) *Client {
+	return newClient(baseURL, openAIPath)
+}
+
+// NewWhisperCpp creates a Client for the whisper.cpp server's /inference endpoint at baseURL.
+func NewWhisperCpp(baseURL string) *Client {
+	return newClient(baseURL, whisperCppPath)
+}
+
+func newClient(baseURL, path string) *Client {
 	return &Client{
 		baseURL: baseURL,
+		path:    path,
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
 	}
 }
 
-// Transcribe uploads an audio file and returns the text transcription.
-func (c *Client) Transcribe(ctx context.Context, filePath string) (string, error) {
-	file, err := os.Open(filePath)
+// Transcribe uploads the audio written by encode as filename and returns the
+// transcription. The audio is streamed into the request body as encode
+// produces it; the filename's extension tells the server the format. encode
+// has returned by the time Transcribe does.
+func (c *Client) Transcribe(ctx context.Context, filename string, encode func(io.Writer) error, opts Options) (Transcription, error) {
+	body, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeMultipart(writer, filename, encode, opts))
+	}()
+	defer func() {
+		// Unblock the encoder if the request ended early, then wait for it.
+		body.Close()
+		<-done
+	}()
+
+	endpoint := c.baseURL + c.path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
-		return "", fmt.Errorf("open audio file: %w", err)
+		return Transcription{}, fmt.Errorf("create request: %w", err)
 	}
-	defer file.Close()
-
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("create form file: %w", err)
-	}
-	if _, err = io.Copy(part, file); err != nil {
-		return "", fmt.Errorf("copy audio data: %w", err)
+		return Transcription{}, fmt.Errorf("transcribe request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	if err := writer.WriteField("language", "ja"); err != nil {
-		return "", fmt.Errorf("set language field: %w", err)
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return Transcription{}, fmt.Errorf("transcribe failed: status %d body %s", resp.StatusCode, string(b))
 	}
 
-	if err := writer.Close(); err != nil {
-		return "", fmt.Errorf("finalize multipart body: %w", err)
+	var result response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Transcription{}, fmt.Errorf("decode transcription: %w", err)
 	}
+	return result.transcription(), nil
+}
 
-	endpoint := fmt.Sprintf("%s/v1/audio/transcriptions", c.baseURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+// TranscribeFile uploads an audio file and returns the transcription.
+func (c *Client) TranscribeFile(ctx context.Context, filePath string, opts Options) (Transcription, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
+		return Transcription{}, fmt.Errorf("open audio file: %w", err)
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	defer file.Close()
 
-	resp, err := c.httpClient.Do(req)
+	return c.Transcribe(ctx, filepath.Base(filePath), func(w io.Writer) error {
+		_, err := io.Copy(w, file)
+		return err
+	}, opts)
+}
+
+func writeMultipart(writer *multipart.Writer, filename string, encode func(io.Writer) error, opts Options) error {
+	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
-		return "", fmt.Errorf("transcribe request: %w", err)
+		return fmt.Errorf("create form file: %w", err)
 	}
-	defer resp.Body.Close()
+	if err := encode(part); err != nil {
+		return fmt.Errorf("encode audio: %w", err)
+	}
+	format, granularities := "json", []string(nil)
+	if opts.Verbose || opts.Words {
+		format, granularities = "verbose_json", []string{"segment"}
+	}
+	if opts.Words {
+		granularities = append(granularities, "word")
+	}
+	fields := [][2]string{
+		{"response_format", format},
+		{"model", opts.Model},
+		{"language", opts.Language},
+		{"prompt", opts.Prompt},
+		{"temperature", formatOptional(opts.Temperature)},
+		{"beam_size", formatNonZero(opts.BeamSize)},
+		{"best_of", formatNonZero(opts.BestOf)},
+	}
+	for _, g := range granularities {
+		fields = append(fields, [2]string{"timestamp_granularities[]", g})
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("set %s field: %w", field[0], err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("finalize multipart body: %w", err)
+	}
+	return nil
+}
 
-	if resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("transcribe failed: status %d body %s", resp.StatusCode, string(b))
+// formatOptional formats v for a form field, or returns "" for nil so the
+// field is omitted.
+func formatOptional(v *float64) string {
+	if v == nil {
+		return ""
 	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
 
-	var result struct {
-		Text string `json:"text"`
+// formatNonZero formats v for a form field, or returns "" for zero so the
+// field is omitted.
+func formatNonZero(v int) string {
+	if v == 0 {
+		return ""
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("decode transcription: %w", err)
+	return strconv.Itoa(v)
+}
+
+// transcription converts the wire format. Servers that only nest word
+// timings in segments (whisper.cpp) have them flattened into Words.
+func (r response) transcription() Transcription {
+	t := Transcription{
+		Text:                r.Text,
+		Language:            cmp.Or(r.Language, r.DetectedLanguage),
+		LanguageProbability: cmp.Or(r.LanguageProbability, r.DetectedLanguageProbability),
+		Duration:            seconds(r.Duration),
+		Words:               words(r.Words),
+	}
+	for _, s := range r.Segments {
+		t.Segments = append(t.Segments, Segment{
+			ID:               s.ID,
+			Start:            seconds(s.Start),
+			End:              seconds(s.End),
+			Text:             s.Text,
+			AvgLogprob:       s.AvgLogprob,
+			NoSpeechProb:     s.NoSpeechProb,
+			CompressionRatio: s.CompressionRatio,
+			Temperature:      s.Temperature,
+		})
+		if len(r.Words) == 0 {
+			t.Words = append(t.Words, words(s.Words)...)
+		}
 	}
-	return result.Text, nil
+	return t
+}
+
+func words(in []responseWord) []Word {
+	var out []Word
+	for _, w := range in {
+		out = append(out, Word{Start: seconds(w.Start), End: seconds(w.End), Word: w.Word, Probability: w.Probability})
+	}
+	return out
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
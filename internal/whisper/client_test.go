@@ -0,0 +1,206 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscribeStreamsMultipartBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("read form file: %v", err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		if header.Filename != "segment.flac" || string(data) != "audio-bytes" {
+			t.Errorf("unexpected upload %q: %q", header.Filename, data)
+		}
+		if lang := r.FormValue("language"); lang != "ja" {
+			t.Errorf("unexpected language %q", lang)
+		}
+		w.Write([]byte(`{"text":"こんにちは"}`))
+	}))
+	defer server.Close()
+
+	result, err := New(server.URL).Transcribe(context.Background(), "segment.flac", func(w io.Writer) error {
+		_, err := io.WriteString(w, "audio-bytes")
+		return err
+	}, Options{Language: "ja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "こんにちは" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+}
+
+func TestTranscribeReportsEncodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	encodeErr := errors.New("encoder exploded")
+	_, err := New(server.URL).Transcribe(context.Background(), "segment.ogg", func(w io.Writer) error {
+		return encodeErr
+	}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "encoder exploded") {
+		t.Fatalf("expected encode error, got %v", err)
+	}
+}
+
+func TestWhisperCppUsesInferenceEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if _, _, err := r.FormFile("file"); err != nil {
+			t.Errorf("read form file: %v", err)
+		}
+		if _, ok := r.MultipartForm.Value["language"]; ok {
+			t.Errorf("expected no language field for auto detection")
+		}
+		if format := r.FormValue("response_format"); format != "json" {
+			t.Errorf("unexpected response format %q", format)
+		}
+		w.Write([]byte(`{"text":" hello\n"}`))
+	}))
+	defer server.Close()
+
+	result, err := NewWhisperCpp(server.URL).Transcribe(context.Background(), "segment.wav", func(w io.Writer) error {
+		_, err := io.WriteString(w, "RIFF")
+		return err
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != " hello\n" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+}
+
+func TestTranscribeVerboseJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if format := r.FormValue("response_format"); format != "verbose_json" {
+			t.Errorf("unexpected response format %q", format)
+		}
+		if got := r.MultipartForm.Value["timestamp_granularities[]"]; strings.Join(got, ",") != "segment,word" {
+			t.Errorf("unexpected granularities %v", got)
+		}
+		w.Write([]byte(`{
+			"text": "こんにちは世界", "language": "ja", "duration": 2.5,
+			"segments": [
+				{"id": 0, "start": 0.0, "end": 1.2, "text": "こんにちは", "avg_logprob": -0.25, "no_speech_prob": 0.01, "compression_ratio": 0.8},
+				{"id": 1, "start": 1.2, "end": 2.5, "text": "世界", "avg_logprob": -1.5, "no_speech_prob": 0.7, "compression_ratio": 2.6}
+			],
+			"words": [
+				{"start": 0.1, "end": 1.0, "word": "こんにちは", "probability": 0.9},
+				{"start": 1.3, "end": 2.2, "word": "世界", "probability": 0.4}
+			]
+		}`))
+	}))
+	defer server.Close()
+
+	result, err := New(server.URL).Transcribe(context.Background(), "segment.wav", func(w io.Writer) error {
+		_, err := io.WriteString(w, "RIFF")
+		return err
+	}, Options{Words: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Language != "ja" || result.Duration != 2500*time.Millisecond || len(result.Segments) != 2 || len(result.Words) != 2 {
+		t.Fatalf("unexpected transcription %+v", result)
+	}
+	seg := result.Segments[1]
+	if seg.Start != 1200*time.Millisecond || seg.End != 2500*time.Millisecond || seg.AvgLogprob != -1.5 || seg.NoSpeechProb != 0.7 || seg.CompressionRatio != 2.6 {
+		t.Fatalf("unexpected segment %+v", seg)
+	}
+	if w := result.Words[1]; w.Word != "世界" || w.Start != 1300*time.Millisecond || w.Probability != 0.4 {
+		t.Fatalf("unexpected word %+v", w)
+	}
+}
+
+func TestTranscribeFlattensSegmentWords(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"text": "a b", "detected_language": "english", "detected_language_probability": 0.9, "segments": [
+			{"start": 0, "end": 1, "text": "a", "words": [{"start": 0, "end": 1, "word": "a"}]},
+			{"start": 1, "end": 2, "text": "b", "words": [{"start": 1, "end": 2, "word": "b"}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	result, err := NewWhisperCpp(server.URL).Transcribe(context.Background(), "segment.wav", func(w io.Writer) error {
+		return nil
+	}, Options{Verbose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Words) != 2 || result.Words[1].Word != "b" || result.Words[1].Start != time.Second {
+		t.Fatalf("unexpected words %+v", result.Words)
+	}
+	if result.Language != "english" || result.LanguageProbability != 0.9 {
+		t.Fatalf("unexpected language %q (%.2f)", result.Language, result.LanguageProbability)
+	}
+}
+
+func TestTranscribeSendsDecodingOptions(t *testing.T) {
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		form = r.MultipartForm.Value
+		w.Write([]byte(`{"text":"hello"}`))
+	}))
+	defer server.Close()
+
+	transcribe := func(opts Options) {
+		t.Helper()
+		_, err := New(server.URL).Transcribe(context.Background(), "segment.wav", func(w io.Writer) error {
+			return nil
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An explicit zero temperature is sent rather than left to the server.
+	temperature := 0.0
+	transcribe(Options{Model: "Systran/faster-whisper-small", Language: "en", Prompt: "Kubernetes, Go", Temperature: &temperature, BeamSize: 5})
+	want := map[string]string{
+		"model":       "Systran/faster-whisper-small",
+		"language":    "en",
+		"prompt":      "Kubernetes, Go",
+		"temperature": "0",
+		"beam_size":   "5",
+	}
+	for key, value := range want {
+		if got := form[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	// Zero values leave the server's defaults.
+	if _, ok := form["best_of"]; ok {
+		t.Errorf("expected no best_of field")
+	}
+
+	transcribe(Options{})
+	if _, ok := form["temperature"]; ok {
+		t.Errorf("expected no temperature field without a temperature")
+	}
+}
@@ -68,6 +68,7 @@ type VoiceConnection struct {
 
 	voiceSpeakingUpdateHandlers []VoiceSpeakingUpdateHandler
 	ssrcMappingHandlers         []SSRCMappingHandler
+	disconnectHandlers          []VoiceDisconnectHandler
 	ssrcMap                     map[uint32]string
 }
 
@@ -79,6 +80,10 @@ type VoiceSpeakingUpdateHandler func(vc *VoiceConnection, vs *VoiceSpeakingUpdat
 // replays an association between an SSRC and a Discord user ID.
 type SSRCMappingHandler func(vc *VoiceConnection, ssrc uint32, userID string)
 
+// VoiceDisconnectHandler is invoked when a user leaves the voice channel, with
+// every SSRC that was mapped to them. ssrcs may be empty if none was known.
+type VoiceDisconnectHandler func(vc *VoiceConnection, userID string, ssrcs []uint32)
+
 // Speaking sends a speaking notification to Discord over the voice websocket.
 // This must be sent as true prior to sending audio and should be set to false
 // once finished sending audio.
@@ -254,6 +259,18 @@ func (v *VoiceConnection) AddSSRCMappingHandler(h SSRCMappingHandler) {
 	}
 }
 
+// AddDisconnectHandler registers a callback for users leaving the voice channel.
+func (v *VoiceConnection) AddDisconnectHandler(h VoiceDisconnectHandler) {
+	if h == nil {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.disconnectHandlers = append(v.disconnectHandlers, h)
+}
+
 // VoiceSpeakingUpdate is a struct for a VoiceSpeakingUpdate event.
 type VoiceSpeakingUpdate struct {
 	UserID   string `json:"user_id"`
@@ -261,6 +278,33 @@ type VoiceSpeakingUpdate struct {
 	Speaking bool   `json:"speaking"`
 }
 
+// UnmarshalJSON accepts the speaking field both as a bool and as the
+// bitmask of speaking modes sent by newer voice gateway versions.
+func (vs *VoiceSpeakingUpdate) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		UserID   string          `json:"user_id"`
+		SSRC     int             `json:"ssrc"`
+		Speaking json.RawMessage `json:"speaking"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	vs.UserID, vs.SSRC = raw.UserID, raw.SSRC
+	vs.Speaking = false
+	if len(raw.Speaking) == 0 || string(raw.Speaking) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Speaking, &vs.Speaking); err == nil {
+		return nil
+	}
+	var mode int
+	if err := json.Unmarshal(raw.Speaking, &mode); err != nil {
+		return fmt.Errorf("invalid speaking value %s: %w", raw.Speaking, err)
+	}
+	vs.Speaking = mode != 0
+	return nil
+}
+
 type voiceClientConnect struct {
 	UserID    string `json:"user_id"`
 	AudioSSRC uint32 `json:"audio_ssrc"`
@@ -290,15 +334,36 @@ func (v *VoiceConnection) setSSRCMapping(ssrc uint32, userID string) {
 	}
 }
 
-func (v *VoiceConnection) removeSSRCMapping(ssrc uint32) {
-	if ssrc == 0 {
-		return
-	}
+// removeUserSSRCs drops every SSRC mapped to userID, plus audioSSRC when the
+// disconnect event carries it, and notifies the disconnect handlers.
+func (v *VoiceConnection) removeUserSSRCs(userID string, audioSSRC uint32) {
 	v.Lock()
-	if v.ssrcMap != nil {
-		delete(v.ssrcMap, ssrc)
+	var removed []uint32
+	for ssrc, mapped := range v.ssrcMap {
+		if (userID != "" && mapped == userID) || (audioSSRC != 0 && ssrc == audioSSRC) {
+			removed = append(removed, ssrc)
+			delete(v.ssrcMap, ssrc)
+		}
+	}
+	if audioSSRC != 0 && !containsSSRC(removed, audioSSRC) {
+		removed = append(removed, audioSSRC)
 	}
+	handlers := append([]VoiceDisconnectHandler(nil), v.disconnectHandlers...)
+	v.log(LogDebug, "removed SSRC mappings user=%s ssrcs=%v handlers=%d", userID, removed, len(handlers))
 	v.Unlock()
+
+	for _, handler := range handlers {
+		handler(v, userID, removed)
+	}
+}
+
+func containsSSRC(ssrcs []uint32, ssrc uint32) bool {
+	for _, s := range ssrcs {
+		if s == ssrc {
+			return true
+		}
+	}
+	return false
 }
 
 func (v *VoiceConnection) userIDForSSRC(ssrc uint32) string {
@@ -604,8 +669,8 @@ func (v *VoiceConnection) onEvent(message []byte) {
 			return
 		}
 		v.log(LogInformational, "voice client disconnect user=%s audio_ssrc=%d", client.UserID, client.AudioSSRC)
-		if client.AudioSSRC != 0 {
-			v.removeSSRCMapping(client.AudioSSRC)
+		if client.UserID != "" || client.AudioSSRC != 0 {
+			v.removeUserSSRCs(client.UserID, client.AudioSSRC)
 		}
 		return
 